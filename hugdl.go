@@ -1,188 +1,1868 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"downloader/internal/hubcache"
+	"downloader/internal/progress"
+	"downloader/internal/ratelimit"
+	"downloader/internal/signals"
+	"downloader/internal/tracing"
+	"downloader/internal/units"
+	"downloader/pkg/hugdl"
+
+	"github.com/schollz/progressbar/v3"
 )
 
-// ModelInfo represents a model file from HuggingFace
-type ModelInfo struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
-	Size int64  `json:"size,omitempty"`
-	Path string `json:"path"`
+// Exit statuses that let scripts tell a partial download from one that never got going.
+// Invalid options and other early errors also exit with 1.
+const (
+	exitPartial = 1 // some files could not be downloaded
+	exitFailure = 2 // nothing was downloaded, e.g. the model does not exist
+)
+
+// defaultEndpoint is the Hub used when neither -endpoint nor HF_ENDPOINT is set
+const defaultEndpoint = "https://huggingface.co"
+
+// defaultOutputDir returns the directory used when no -output is given: models/ under
+// $HF_HOME, or under the user's cache directory (e.g. ~/.cache/huggingface) like the
+// official client. It falls back to ./models if neither can be determined.
+func defaultOutputDir() string {
+	home := os.Getenv("HF_HOME")
+	if home == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "models"
+		}
+		home = filepath.Join(cache, "huggingface")
+	}
+	return filepath.Join(home, "models")
+}
+
+// memoryBudget returns the -max-memory value, or the available RAM when it is empty
+func memoryBudget(maxMemory string) (int64, error) {
+	if maxMemory != "" {
+		budget, err := units.ParseByteSize(maxMemory)
+		if err != nil {
+			return 0, fmt.Errorf("invalid -max-memory: %w", err)
+		}
+		return budget, nil
+	}
+
+	available, err := availableMemory()
+	if err != nil {
+		return 0, fmt.Errorf("could not detect available memory (%v), set -max-memory", err)
+	}
+	return available, nil
+}
+
+// availableMemory reads MemAvailable from /proc/meminfo (Linux only)
+func availableMemory() (int64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
+
+// stringList is a flag.Value collecting repeated string flags
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func main() {
+	defaultDir := defaultOutputDir()
+
 	// Command line flags
 	var (
 		modelName = flag.String("model", "Qwen/Qwen2.5-Coder-0.5B", "Model name (e.g., Qwen/Qwen2.5-Coder-0.5B)")
-		outputDir = flag.String("output", "C:\\Users\\user\\hf\\models", "Output directory for downloaded files")
+		revision  = flag.String("revision", "main", "Branch, tag or commit hash to download (e.g. v1.0, refs/pr/3)")
 		help      = flag.Bool("help", false, "Show help message")
+		modelInfo = flag.Bool("model-info", false, "Print model metadata and exit (as JSON with -json)")
+		maxFiles  = flag.Int("max-files", 0, "Download at most N files (0 = no limit)")
+		hardlink  = flag.Bool("hardlink-existing", false, "Hardlink files found by -exclude-existing-in into the output directory")
+		format    = flag.String("output-format", hugdl.LayoutNested, "Output layout: nested (repo paths), flat (file names only), hub (HuggingFace cache)")
+		verifyDir = flag.String("verify-dir", "", "Verify an existing local copy of the model against the repo's hashes and exit")
+		sumCache  = flag.Bool("checksum-cache", false, "Remember file hashes by size and modification time in a sidecar and trust unchanged files instead of hashing them again")
+		maxAge    = flag.Duration("max-age", 0, "Stop trusting state and checksum-cache sidecar entries older than this and check the files against the repo again (e.g. 168h; 0 = no limit)")
+		verifyN   = flag.Int("verify-threads", 0, "Maximum number of local files hashed in parallel by verification, -download-if-changed-checksum and -exclude-existing-in (0 = number of CPUs)")
+		matchExpr = flag.String("match-regexp", "", "Only download files whose repo path matches this regular expression")
+		includes  = flag.String("include", "", "Only download files whose repo path matches one of these comma-separated globs, e.g. \"*.json,*Q4_K_M*\" (case-insensitive)")
+		excludes  = flag.String("exclude", "", "Skip files whose repo path matches one of these comma-separated globs; wins over -include")
+		inclFrom  = flag.String("include-pattern-from", "", "Read more -include globs from this file, one per line; # starts a comment")
+		exclFrom  = flag.String("exclude-pattern-from", "", "Read more -exclude globs from this file, one per line; # starts a comment")
+		cacheDir  = flag.String("cache-dir", "", "Directory for hugdl's sidecar files and partial downloads (default: alongside the model files)")
+		skipLFS   = flag.Bool("skip-lfs", false, "Skip LFS-tracked files (download only small files like configs and tokenizers)")
+		onlyLFS   = flag.Bool("only-lfs", false, "Download only LFS-tracked files (the large weights)")
+		skipLinks = flag.Bool("skip-symlinks", true, "Skip symlink entries in the repo tree (use -skip-symlinks=false to download their targets)")
+		skipSubs  = flag.Bool("skip-submodules", true, "Skip git submodule pointers in the repo tree (use -skip-submodules=false to try them)")
+		gitAttrs  = flag.Bool("gitattributes", false, "Also treat files matching filter=lfs patterns in the repo's .gitattributes as LFS")
+		maxRate   = flag.String("max-rate", "0", "Cap the combined download speed of all files, e.g. 5MB or 500KB per second (0 = unlimited)")
+		schedule  = flag.String("schedule", "", "Time-of-day rate limits, e.g. \"09:00-18:00=5MB,18:00-09:00=0\" (0 = unlimited)")
+		selfTest  = flag.Bool("selftest", false, "Download a tiny public model to a temp directory, verify it and report pass/fail")
+		stripPre  = flag.String("strip-prefix", "", "Remove this prefix from repo paths when computing local paths (nested layout)")
+		stripN    = flag.Int("strip-components", 0, "Drop the first N directories from repo paths when computing local paths, like tar (nested layout)")
+		pathTmpl  = flag.String("path-template", "", "Local path template for the nested layout, using {path}, {dir} and {name} (e.g. \"train/{path}\")")
+		deepSync  = flag.Bool("download-if-changed-checksum", false, "Skip files whose local copy hashes to the repo oid; re-download only real content changes")
+		workers   = flag.Int("concurrency", 0, "Number of files downloaded in parallel (0 = one per CPU, 2 to 8)")
+		noVerify  = flag.Bool("no-verify", false, "Do not check downloaded files against the repo's SHA256/git hashes")
+		fixMode   = flag.Bool("verify-and-fix", false, "Verify every local file and download the missing or corrupt ones in one pass; exits 1 unless all files end up correct")
+		sizeCheck = flag.Bool("resume-check-remote-size", false, "Before resuming a .part file, check with a HEAD request that the remote size still matches and restart if it changed")
+		resumeChk = flag.Bool("resume-verify", false, "Before resuming a .part file, compare its last 64 KiB with the same bytes from the server and restart if they differ")
+		retries   = flag.Int("retries", 3, "Retry a file this many times on network errors (including dropped connections), HTTP 429 and 5xx, with exponential backoff")
+		retriesGB = flag.Int("retries-per-gb", 0, "Extra retries for every full GiB of a file, on top of -retries")
+		sumRetry  = flag.Int("retry-on-checksum-mismatch", 0, "Download a file again from the start up to this many times when its content fails verification")
+		force     = flag.Bool("force", false, "Download every file again, even if a local copy with the expected size exists")
+		dryRun    = flag.Bool("dry-run", false, "Show what would be downloaded, skipped or linked and the bytes to transfer, without writing anything")
+		filePerm  = flag.String("file-perm", "", "Octal permissions for downloaded files, e.g. 0640, applied exactly (default: 0666 minus the umask)")
+		preAlloc  = flag.Bool("pre-allocate", false, "Reserve disk space for each file before downloading to reduce fragmentation and fail fast when space is short")
+		autoQuant = flag.Bool("auto-quant", false, "Download only the largest GGUF quantization that fits in memory (or the smallest if none fit)")
+		maxMemory = flag.String("max-memory", "", "Memory budget for -auto-quant, e.g. 8GB (default: detected available RAM)")
+		quarMode  = flag.Bool("quarantine", false, "Move files that fail verification to a quarantine/ folder instead of deleting them")
+		explain   = flag.Bool("explain", false, "Print why each file was downloaded or skipped")
+		order     = flag.String("order", hugdl.OrderPath, "Download order: path (sorted by repo path), size (smallest first), size-desc (largest first) or index (shard index and configs first, then shards in index order)")
+		listCache = flag.Bool("list-cached", false, "List the models already downloaded in the output directories with their sizes and exit")
+		checkHub  = flag.Bool("list-cached-remote", false, "With -list-cached, compare each model's downloaded commit with the Hub")
+		gcCache   = flag.Bool("gc", false, "Remove the least recently used hub cache blobs that no snapshot links to until the cache fits -cache-max-size, then exit")
+		cacheMax  = flag.String("cache-max-size", "0", "Size budget for -gc, e.g. 50GB (0 = remove every unreferenced blob)")
+		exportDir = flag.String("export-dir", "", "Copy the model's snapshot from the hub cache in -output to this directory as regular files (symlinks resolved) and exit")
+		streamCmd = flag.String("stream-to-command", "", "Pipe each downloaded file's bytes to this shell command's stdin; the repo path is in $1 and $HUGDL_PATH")
+		quiet     = flag.Bool("quiet", false, "Print nothing but errors: no banner, per-file messages or progress bars (the exit code still reports failures)")
+		jsonOut   = flag.Bool("json", false, "Print a JSON report of every file's result and a summary to stdout; progress messages go to stderr")
+		list      = flag.Bool("list", false, "Print the selected files (path, type, size) instead of downloading them and exit; JSON with -json")
+		listFmt   = flag.String("list-output", "", "Print the selected files instead of downloading them, as a table, json or csv (path, size, type, lfs, oid), and exit")
+		listRefs  = flag.Bool("list-revisions", false, "List the model's branches, tags and converts and exit")
+		doneMark  = flag.Bool("emit-done-marker", false, "Write a .hugdl-complete file (commit, time, file count) once every file is downloaded and verified")
+		jsonIndex = flag.Bool("output-json-index", false, "Write a .hugdl-index.json describing the downloaded files (path, size, oid, url, commit)")
+		lineEnds  = flag.String("normalize-line-endings", "", "Rewrite line endings of text files (.json, .txt, .md) after verification: lf or crlf")
+		endpoint  = flag.String("endpoint", "", "Base URL of the HuggingFace Hub or a mirror, e.g. https://hf-mirror.com (default: $HF_ENDPOINT or "+defaultEndpoint+")")
+		authMap   = flag.String("endpoint-auth-map", "", "JSON file mapping hosts to their own credentials, e.g. {\"hf-mirror.com\": {\"token\": \"...\", \"headers\": {\"X-Api-Key\": \"...\"}}}")
+		token     = flag.String("token", "", "HuggingFace access token for gated and private models (default: $HF_TOKEN)")
+		gateCheck = flag.Bool("fail-if-gated-without-token", false, "Check the model info first and stop if the model is gated or private and no token is set")
+		strictSum = flag.Bool("abort-on-first-checksum-mismatch", false, "Stop the whole run as soon as one file fails checksum verification")
+		manifest  = flag.String("download-manifest-url", "", "Read the file list from this JSON array of {path,size,oid} instead of the tree API")
+		sigKey    = flag.String("verify-manifest-signature", "", "Refuse the -download-manifest-url manifest unless it carries a valid ed25519 signature by this base64 public key (or key file)")
+		sigURL    = flag.String("manifest-signature-url", "", "URL of the manifest's detached signature for -verify-manifest-signature (default: the manifest URL + .sig)")
+		traceFile = flag.String("http-trace-file", "", "Append one JSON record per HTTP request (timings, TLS, redirects, status) to this file")
+		totalOnly = flag.Bool("total-progress-only", false, "Show a single progress bar for the whole model instead of per-file messages (default: on for multi-file downloads to a terminal)")
+		etaFormat = flag.String("progress-eta-format", etaDuration, "ETA shown by -total-progress-only: duration (time left) or absolute (predicted completion time)")
+		maxName   = flag.Int("max-filename-length", 0, "Shorten local file and directory names longer than this many bytes, keeping the extension and adding a hash (0 = no limit)")
+		binFrames = flag.String("progress-callback-binary", "", "Write progress as length-prefixed binary frames to this file or pipe (e.g. /dev/fd/3) for embedding applications")
+		frameRate = flag.Duration("progress-update-webhook-interval", progress.DefaultInterval, "Minimum time between two byte-count frames of one file on -progress-callback-binary")
+		noReuse   = flag.Bool("disable-keepalive", false, "Open a new connection for every request instead of reusing them (slower; for proxies that break reused connections)")
+		proxy     = flag.String("proxy", "", "Send all requests through this proxy, e.g. http://proxy:3128 or socks5://127.0.0.1:1080 (default: $HTTPS_PROXY/$HTTP_PROXY, honoring $NO_PROXY)")
+		minTLS    = flag.String("min-tls", "", "Minimum TLS version for HTTPS connections: 1.2 or 1.3 (default: Go's default)")
+		sinceRev  = flag.String("since-commit", "", "Only download files added or changed since this commit (or other revision), e.g. the last one you downloaded")
+		runLimit  = flag.Duration("timeout", 0, "Stop the whole run after this long, keeping partial downloads for resuming (e.g. 2h; 0 = no limit)")
+		noSpace   = flag.Bool("skip-space-check", false, "Start even if the output volume looks too small for the files to download")
+		diskWait  = flag.Duration("disk-full-wait", 0, "When the disk fills up, wait this long for free space before failing (e.g. 10m; 0 = fail immediately)")
 	)
+	var outputDirs, existingDirs stringList
+	flag.Var(&outputDirs, "output", "Output directory for downloaded files; repeat to write mirrors (default "+defaultDir+")")
+	flag.Var(&existingDirs, "exclude-existing-in", "Skip files already present in this directory (repeatable)")
 	flag.Parse()
 
-	
 	// Show help if requested
 	if *help {
-		fmt.Println("🚀 hugdl - Fast HuggingFace Model Downloader")
-		fmt.Println(strings.Repeat("=", 50))
-		fmt.Println("Usage: hugdl [options]")
-		fmt.Println("")
-		fmt.Println("Options:")
+		fmt.Fprintln(out, "🚀 hugdl - Fast HuggingFace Model Downloader")
+		fmt.Fprintln(out, strings.Repeat("=", 50))
+		fmt.Fprintln(out, "Usage: hugdl [options]")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Options:")
 		flag.PrintDefaults()
-		fmt.Println("")
-		fmt.Println("Examples:")
-		fmt.Println("  hugdl -model Qwen/Qwen2.5-Coder-0.5B")
-		fmt.Println("  hugdl -model microsoft/DialoGPT-medium")
-		fmt.Println("  hugdl -model meta-llama/Llama-2-7b-chat-hf -output D:\\models")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Examples:")
+		fmt.Fprintln(out, "  hugdl -model Qwen/Qwen2.5-Coder-0.5B")
+		fmt.Fprintln(out, "  hugdl -model microsoft/DialoGPT-medium")
+		fmt.Fprintln(out, "  hugdl -model meta-llama/Llama-2-7b-chat-hf -output D:\\models")
+		fmt.Fprintln(out, "  hugdl -model Qwen/Qwen2.5-Coder-0.5B -model-info")
+		fmt.Fprintln(out, "  hugdl -model Qwen/Qwen2.5-Coder-0.5B -list-revisions")
+		fmt.Fprintln(out, "  hugdl -model Qwen/Qwen2.5-Coder-0.5B -revision refs/pr/3")
+		fmt.Fprintln(out, "  hugdl -model Qwen/Qwen2.5-Coder-0.5B -verify-dir D:\\models\\Qwen_Qwen2.5-Coder-0.5B")
+		fmt.Fprintln(out, "  hugdl -model meta-llama/Llama-2-7b-chat-hf -token hf_xxx")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Environment:")
+		fmt.Fprintln(out, "  HF_TOKEN     Access token for gated and private models, used when -token is not set")
+		fmt.Fprintln(out, "  HF_ENDPOINT  Hub or mirror base URL, used when -endpoint is not set")
+		fmt.Fprintln(out, "  HF_HOME      Directory whose models/ folder is the default -output (default: the user cache directory's huggingface/)")
 		return
 	}
 
-	fmt.Println("🚀 hugdl - Fast HuggingFace Model Downloader")
-	fmt.Println(strings.Repeat("=", 50))
+	// Configure the transport and credentials before any network access
+	if err := configureNetwork(*minTLS, *noReuse, *proxy, *token, *authMap); err != nil {
+		fmt.Fprintf(errOut, "❌ Invalid %v\n", err)
+		os.Exit(1)
+	}
 
-	// Configuration
-	baseURL := "https://huggingface.co"
-	apiURL := "https://huggingface.co/api"
+	// Trace every request if requested
+	if *traceFile != "" {
+		f, err := os.OpenFile(*traceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error opening trace file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		httpClient.Transport = &tracing.Transport{Base: httpTransport, Out: f}
+	}
 
-	// Create model directory name
-	modelDirName := strings.ReplaceAll(*modelName, "/", "_")
-	modelDir := filepath.Join(*outputDir, modelDirName)
+	// Stream binary progress frames to an embedding application if requested
+	var frames *progress.Stream
+	if *binFrames != "" {
+		f, err := os.OpenFile(*binFrames, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error opening progress stream: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		frames = progress.New(f, *frameRate, func(err error) {
+			fmt.Fprintf(out, "⚠️  Progress stream closed: %v\n", err)
+		})
+	}
 
-	fmt.Printf("📦 Model: %s\n", *modelName)
-	fmt.Printf("📁 Output: %s\n", modelDir)
-	fmt.Println(strings.Repeat("=", 50))
+	// Cancel the run on Ctrl-C or SIGTERM; a second signal quits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *runLimit > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *runLimit)
+		defer cancel()
+	}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		fmt.Fprintln(out, "\n🛑 Interrupted, stopping downloads (press Ctrl-C again to quit immediately)")
+		cancel()
+	}()
 
-	// Step 1: Get model file list
-	fmt.Println("🔍 Checking available files...")
-	files, err := getModelFiles(apiURL, *modelName)
+	// Configuration: the Hub or a mirror
+	if *endpoint == "" {
+		*endpoint = os.Getenv("HF_ENDPOINT")
+	}
+	if *endpoint == "" {
+		*endpoint = defaultEndpoint
+	}
+	baseURL, err := parseEndpoint(*endpoint)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ Invalid endpoint: %v\n", err)
+		os.Exit(1)
+	}
+	hub.Endpoint = baseURL
+
+	// A signed manifest is only trusted with the key it was signed with
+	source := fileSource{manifestURL: *manifest, sigURL: *sigURL}
+	if *sigKey != "" {
+		if *manifest == "" {
+			fmt.Fprintln(errOut, "❌ -verify-manifest-signature needs -download-manifest-url")
+			os.Exit(1)
+		}
+		source.key, err = hugdl.ParseManifestKey(*sigKey)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Invalid -verify-manifest-signature: %v\n", err)
+			os.Exit(1)
+		}
+		if source.sigURL == "" {
+			source.sigURL = *manifest + ".sig"
+		}
+	}
+
+	// Print model metadata instead of downloading if requested
+	if *modelInfo {
+		details, err := hub.Details(ctx, *modelName, *revision)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error getting model info: %v\n", err)
+			os.Exit(1)
+		}
+		if err := printModelDetails(os.Stdout, details, *jsonOut); err != nil {
+			fmt.Fprintf(errOut, "❌ Error writing model info: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// List branches and tags instead of downloading if requested
+	if *listRefs {
+		refs, err := hub.Refs(ctx, *modelName)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error getting revisions: %v\n", err)
+			os.Exit(1)
+		}
+		printModelRefs(*modelName, refs)
+		return
+	}
+
+	// Show what is already on disk instead of downloading if requested
+	if *listCache {
+		dirs := outputDirs
+		if len(dirs) == 0 {
+			dirs = stringList{defaultDir}
+		}
+		for _, dir := range dirs {
+			models, err := hubcache.Scan(dir)
+			if err != nil {
+				fmt.Fprintf(errOut, "❌ Error scanning %s: %v\n", dir, err)
+				os.Exit(1)
+			}
+			printCached(ctx, dir, models, *checkHub)
+		}
+		return
+	}
+
+	// Materialize a hub cache snapshot as plain files if requested
+	if *exportDir != "" {
+		dir := defaultDir
+		if len(outputDirs) > 0 {
+			dir = outputDirs[0]
+		}
+		copied, err := hubcache.Export(dir, *modelName, *revision, *exportDir)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(out, "📤 Exported %d files to %s\n", copied, *exportDir)
+		return
+	}
+
+	// Prune the hub cache instead of downloading if requested
+	if *gcCache {
+		budget, err := units.ParseByteSize(*cacheMax)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Invalid -cache-max-size: %v\n", err)
+			os.Exit(1)
+		}
+		dirs := outputDirs
+		if len(dirs) == 0 {
+			dirs = stringList{defaultDir}
+		}
+		for _, dir := range dirs {
+			result, err := hubcache.GC(dir, budget, *dryRun)
+			if err != nil {
+				fmt.Fprintf(errOut, "❌ Error cleaning %s: %v\n", dir, err)
+				os.Exit(1)
+			}
+			printGC(dir, budget, result, *dryRun)
+		}
+		return
+	}
+
+	// Parse the bandwidth limits before any network access; schedule windows override -max-rate
+	baseRate, err := units.ParseByteSize(*maxRate)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ Invalid -max-rate: %v\n", err)
+		os.Exit(1)
+	}
+	limiter := ratelimit.New(baseRate)
+	limiter.OnChange = func(rate int64) {
+		fmt.Fprintf(out, "   🕒 Bandwidth limit now %s\n", units.FormatRate(rate))
+	}
+	if *schedule != "" {
+		windows, err := ratelimit.ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Invalid -schedule: %v\n", err)
+			os.Exit(1)
+		}
+		limiter.Schedule = windows
+	}
+
+	var fileMode os.FileMode
+	if *filePerm != "" {
+		fileMode, err = parseFileMode(*filePerm)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Invalid -file-perm: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *skipLFS && *onlyLFS {
+		fmt.Fprintln(errOut, "❌ -skip-lfs and -only-lfs cannot be combined")
+		os.Exit(1)
+	}
+
+	if *lineEnds != "" {
+		if *lineEnds != hugdl.LineEndingsLF && *lineEnds != hugdl.LineEndingsCRLF {
+			fmt.Fprintf(errOut, "❌ Invalid -normalize-line-endings %q (want lf or crlf)\n", *lineEnds)
+			os.Exit(1)
+		}
+		if *format == hugdl.LayoutHub {
+			fmt.Fprintln(errOut, "❌ -normalize-line-endings cannot rewrite content-addressed hub blobs")
+			os.Exit(1)
+		}
+	}
+
+	if *workers < 0 {
+		fmt.Fprintln(errOut, "❌ -concurrency cannot be negative")
+		os.Exit(1)
+	}
+	if *retriesGB < 0 {
+		fmt.Fprintln(errOut, "❌ -retries-per-gb cannot be negative")
+		os.Exit(1)
+	}
+	if *maxAge < 0 {
+		fmt.Fprintln(errOut, "❌ -max-age cannot be negative")
+		os.Exit(1)
+	}
+
+	// -verify-and-fix is a deep sync that insists on a complete, verified result
+	if *fixMode {
+		if *noVerify {
+			fmt.Fprintln(errOut, "❌ -verify-and-fix cannot be combined with -no-verify")
+			os.Exit(1)
+		}
+		*deepSync = true
+	}
+
+	*listFmt = listFormat(*list, *jsonOut, *listFmt)
+
+	// Machine-readable output owns stdout; everything else goes to stderr
+	dataOut := os.Stdout
+	if *jsonOut {
+		out = os.Stderr
+	}
+	switch *listFmt {
+	case "", listTable:
+	case listJSON, listCSV:
+		out = os.Stderr
+	default:
+		fmt.Fprintf(errOut, "❌ Invalid -list-output %q (want %s, %s or %s)\n", *listFmt, listTable, listJSON, listCSV)
+		os.Exit(1)
+	}
+
+	// Quiet runs keep errors where status messages would go and drop everything else
+	errOut = out
+	if *quiet {
+		if *explain || *dryRun {
+			fmt.Fprintln(errOut, "❌ -quiet cannot be combined with -explain or -dry-run, whose output is the point")
+			os.Exit(1)
+		}
+		out = io.Discard
+	}
+
+	if *etaFormat != etaDuration && *etaFormat != etaAbsolute {
+		fmt.Fprintf(errOut, "❌ Invalid -progress-eta-format %q (want %s or %s)\n", *etaFormat, etaDuration, etaAbsolute)
+		os.Exit(1)
+	}
+	if *etaFormat == etaAbsolute && flagSet("total-progress-only") && !*totalOnly {
+		fmt.Fprintln(errOut, "❌ -progress-eta-format absolute needs -total-progress-only")
+		os.Exit(1)
+	}
+
+	// Compile the path filters up front so a bad expression fails before any network access
+	selection := hugdl.Selection{
+		SkipSymlinks:   *skipLinks,
+		SkipSubmodules: *skipSubs,
+		SkipLFS:        *skipLFS,
+		OnlyLFS:        *onlyLFS,
+		Order:          *order,
+		MaxFiles:       *maxFiles,
+	}
+	if *matchExpr != "" {
+		selection.Regexp, err = regexp.Compile(*matchExpr)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Invalid -match-regexp: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	selection.Include, err = loadGlobs(*includes, *inclFrom)
 	if err != nil {
-		fmt.Printf("❌ Error getting model files: %v\n", err)
+		fmt.Fprintf(errOut, "❌ Invalid -include: %v\n", err)
 		os.Exit(1)
 	}
+	selection.Exclude, err = loadGlobs(*excludes, *exclFrom)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ Invalid -exclude: %v\n", err)
+		os.Exit(1)
+	}
+
+	// -verify-threads bounds every hash of a local file, whichever mode asks for it
+	verifyWorkers := *verifyN
+	if verifyWorkers <= 0 {
+		verifyWorkers = runtime.NumCPU()
+	}
+
+	fmt.Fprintln(out, "🚀 hugdl - Fast HuggingFace Model Downloader")
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+
+	// Exercise listing, download and verification end to end if requested
+	if *selfTest {
+		if err := runSelfTest(ctx, selfTestModel); err != nil {
+			fmt.Fprintf(errOut, "❌ Self-test failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(out, "🎉 Self-test passed")
+		return
+	}
+
+	// Audit an existing directory instead of downloading if requested
+	if *verifyDir != "" {
+		stateDir := *verifyDir
+		if *cacheDir != "" {
+			stateDir = filepath.Join(*cacheDir, hugdl.ModelDirName(*modelName))
+		}
+		var cache *hugdl.ChecksumCache
+		if *sumCache {
+			cache = hugdl.LoadChecksumCache(stateDir)
+			cache.SetMaxAge(*maxAge)
+		}
+		hasher := hugdl.NewHasher(verifyWorkers, cache)
+		os.Exit(verifyDirectory(ctx, *verifyDir, stateDir, *modelName, *revision, source, hasher, verifyWorkers, *maxAge))
+	}
+
+	// Stop before any download if the model needs a token that is not configured
+	if *gateCheck && hub.Token == "" {
+		restricted, err := needsToken(ctx, *modelName, *revision)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error getting model info: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if restricted {
+			fmt.Fprintf(errOut, "❌ %s is gated or private; accept its terms on the Hub and pass -token or set HF_TOKEN\n", *modelName)
+			os.Exit(1)
+		}
+	}
+
+	// The hub layout names snapshots after the commit they belong to
+	commit := ""
+	if *format == hugdl.LayoutHub {
+		details, err := hub.Details(ctx, *modelName, *revision)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error getting model info: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		commit = details.Sha
+	}
+
+	if len(outputDirs) == 0 {
+		outputDirs = stringList{defaultDir}
+	}
+	var layouts []hugdl.Layout
+	for _, dir := range outputDirs {
+		layout, err := hugdl.NewLayout(*format, dir, *modelName, *revision, commit)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		if *cacheDir != "" {
+			layout.CacheDir = filepath.Join(*cacheDir, hugdl.ModelDirName(*modelName))
+		}
+		if *stripPre != "" || *stripN != 0 || *pathTmpl != "" {
+			if *format != hugdl.LayoutNested {
+				fmt.Fprintln(errOut, "❌ -strip-prefix, -strip-components and -path-template only apply to the nested output format")
+				os.Exit(1)
+			}
+			if *stripN < 0 {
+				fmt.Fprintln(errOut, "❌ -strip-components must not be negative")
+				os.Exit(1)
+			}
+			layout.Transform = hugdl.PathTransform{StripPrefix: *stripPre, StripComponents: *stripN, Template: *pathTmpl}
+		}
+		if *maxName != 0 {
+			if *maxName < hugdl.MinNameLength {
+				fmt.Fprintf(errOut, "❌ -max-filename-length must be at least %d\n", hugdl.MinNameLength)
+				os.Exit(1)
+			}
+			layout.MaxName = *maxName
+		}
+		layouts = append(layouts, layout)
+	}
+	selection.Transform = layouts[0].Transform
+
+	fmt.Fprintf(out, "📦 Model: %s\n", *modelName)
+	for _, layout := range layouts {
+		fmt.Fprintf(out, "📁 Output: %s\n", layout.ModelDir)
+	}
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+
+	// Step 1: Get model file list. Dry runs and listings must not write anything,
+	// including the listing cache.
+	cachePath := ""
+	if !*dryRun && *listFmt == "" {
+		cachePath = filepath.Join(layouts[0].StateDir(), hugdl.TreeCacheName(*revision))
+	}
+	files, err := listModel(ctx, *modelName, *revision, source, cachePath, newDiscoveryCounter())
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ Error getting model files: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Fprintf(out, "✅ Found %d files\n", len(files))
+
+	var why *hugdl.Explainer
+	if *explain {
+		why = hugdl.NewExplainer(printExplanation)
+	}
 
-	fmt.Printf("✅ Found %d files\n", len(files))
+	// Fill in LFS tracking the tree listing did not report
+	if *gitAttrs {
+		marked, err := hub.ApplyGitAttributes(ctx, *modelName, *revision, files)
+		if err != nil {
+			fmt.Fprintf(out, "⚠️  Could not apply .gitattributes: %v\n", err)
+		} else if marked > 0 {
+			fmt.Fprintf(out, "📎 %d more files are LFS-tracked per .gitattributes\n", marked)
+		}
+	}
+
+	// Keep only files whose content changed since a known commit. Local copies of
+	// those are hashed rather than trusted by size, since an edit may keep the size.
+	if *sinceRev != "" {
+		fmt.Fprintf(out, "🔍 Comparing with %s...\n", *sinceRev)
+		previous, err := hub.ListTree(ctx, *modelName, *sinceRev, hugdl.TreeOptions{})
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ Error getting model files at %s: %v\n", *sinceRev, err)
+			os.Exit(1)
+		}
+		selection.Since, selection.Previous = *sinceRev, previous
+	}
+
+	// Pick a single GGUF quantization that fits the memory budget
+	if *autoQuant {
+		selection.AutoQuant = true
+		selection.MemoryBudget, err = memoryBudget(*maxMemory)
+		if err != nil {
+			fmt.Fprintf(errOut, "❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	// Step 2: Create output directory
-	if err := os.MkdirAll(modelDir, 0755); err != nil {
-		fmt.Printf("❌ Error creating directory: %v\n", err)
+	// The index order follows the shard sequence of the checkpoint indexes
+	selection.Shards = func(files []hugdl.File) ([]string, error) {
+		return hub.ShardOrder(ctx, *modelName, *revision, files)
+	}
+
+	selected, err := selection.Apply(files, why)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
 		os.Exit(1)
 	}
+	printSelection(selection, selected)
+	files = selected.Files
+
+	// Print the selection instead of downloading it if requested
+	if *listFmt != "" {
+		if err := printListing(dataOut, files, *listFmt); err != nil {
+			fmt.Fprintf(errOut, "❌ Error writing listing: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var cache *hugdl.ChecksumCache
+	if *sumCache {
+		cache = hugdl.LoadChecksumCache(layouts[0].StateDir())
+		cache.SetMaxAge(*maxAge)
+	}
+	state := hugdl.LoadState(layouts[0].StateDir(), *modelName, *revision)
+	state.SetMaxAge(*maxAge)
+	planner := hugdl.Planner{
+		ExistingDirs: existingDirs,
+		Hardlink:     *hardlink,
+		DeepSync:     *deepSync || *sinceRev != "",
+		Force:        *force,
+		State:        state,
+		Hasher:       hugdl.NewHasher(verifyWorkers, cache),
+		Explain:      why,
+	}
+
+	// Preview the plan without touching the disk if requested
+	if *dryRun {
+		if err := printPlan(files, layouts, planner); err != nil {
+			fmt.Fprintf(errOut, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Step 2: Download all files
+	opts := hugdl.DownloadAllOptions{
+		Model:        *modelName,
+		Revision:     *revision,
+		Concurrency:  *workers,
+		Retries:      *retries,
+		RetriesPerGB: *retriesGB,
+		NoVerify:     *noVerify,
+		Files:        files,
+		Layouts:      layouts,
+		Planner:      planner,
+		FileOptions: hugdl.FileOptions{
+			DiskFullWait:    *diskWait,
+			Limiter:         limiter,
+			PreAllocate:     *preAlloc,
+			ChecksumRetries: *sumRetry,
+			FileMode:        fileMode,
+			CheckRemoteSize: *sizeCheck,
+			VerifyResume:    *resumeChk,
+		},
+		CheckSpace:              !*noSpace,
+		Quarantine:              *quarMode,
+		LineEndings:             *lineEnds,
+		AbortOnChecksumMismatch: *strictSum,
+		WriteIndex:              *jsonIndex,
+		DoneMarker:              *doneMark,
+		Warn:                    printWarning,
+	}
+	if *streamCmd != "" {
+		opts.OpenSink = func(ctx context.Context, file hugdl.File, localPath string) (hugdl.Sink, error) {
+			return startStreamCommand(ctx, *streamCmd, file, localPath)
+		}
+	}
+
+	show := display{eta: *etaFormat, frames: frames, fixMode: *fixMode, runLimit: *runLimit}
+	show.aggregate = compactProgress(*totalOnly, flagSet("total-progress-only"), *quiet, *etaFormat, len(files), isTerminal(os.Stderr))
+	if *jsonOut {
+		show.report = dataOut
+	}
+	os.Exit(download(ctx, opts, show))
+}
+
+// compactProgress reports whether one bar covers the whole model with per-file
+// messages hidden. Unless -total-progress-only is given, it is the default when
+// several files go to a terminal, and needed for an absolute ETA; logs keep the messages.
+func compactProgress(totalOnly, explicit, quiet bool, eta string, files int, terminal bool) bool {
+	if quiet {
+		return false
+	}
+	if explicit {
+		return totalOnly
+	}
+	return eta == etaAbsolute || (files > 1 && terminal)
+}
+
+// display is how download presents a run
+type display struct {
+	aggregate bool // one progress bar for the whole model instead of per-file messages
+	eta       string
+	frames    *progress.Stream
+	report    io.Writer // receives the -json report, if set
+	fixMode   bool
+	runLimit  time.Duration
+}
+
+// download runs opts, printing each file's progress and the summary, and returns the exit status
+func download(ctx context.Context, opts hugdl.DownloadAllOptions, show display) int {
+	files := opts.Files
+	// Frames still queued for a slow reader are written before the process exits
+	defer show.frames.Close()
+	fmt.Fprintln(out, "\n📥 Starting downloads...")
+	fmt.Fprintln(out, strings.Repeat("-", 50))
+
+	var bar *progressbar.ProgressBar
+	var total io.Writer
+	status := func(format string, args ...any) {
+		if bar == nil {
+			fmt.Fprintf(out, format, args...)
+		}
+	}
+	skipped := func(size int64) {
+		if bar != nil {
+			bar.Add64(size)
+		}
+	}
+	if show.aggregate {
+		var size int64
+		for _, file := range files {
+			size += file.Size
+		}
+		desc := fmt.Sprintf("📦 %d files", len(files))
+		if show.eta == etaAbsolute {
+			bar = progressbar.NewOptions64(size,
+				progressbar.OptionSetDescription(desc),
+				progressbar.OptionSetWriter(os.Stderr),
+				progressbar.OptionShowBytes(true),
+				progressbar.OptionShowTotalBytes(true),
+				progressbar.OptionSetWidth(10),
+				progressbar.OptionThrottle(65*time.Millisecond),
+				progressbar.OptionShowCount(),
+				progressbar.OptionSetPredictTime(false),
+				progressbar.OptionOnCompletion(func() { fmt.Fprint(os.Stderr, "\n") }),
+				progressbar.OptionFullWidth(),
+				progressbar.OptionSetRenderBlankState(true),
+			)
+			total = &etaWriter{bar: bar, desc: desc, start: time.Now()}
+		} else {
+			bar = progressbar.DefaultBytes(size, desc)
+			total = bar
+		}
+
+		// The bar measures the terminal on every render; redraw right away on resize
+		// so a shrinking window does not leave a wrapped, stale line until more bytes arrive
+		stopResize := onResize(func() {
+			fmt.Fprint(os.Stderr, "\033[2K\r")
+			bar.RenderBlank()
+		})
+		defer stopResize()
+	}
+
+	opts.Writers = func(i int, file hugdl.File) []io.Writer {
+		var writers []io.Writer
+		if bar != nil {
+			writers = append(writers, total)
+		}
+		if counter := show.frames.Counter(i); counter != nil {
+			writers = append(writers, counter)
+		}
+		return writers
+	}
+	opts.OnStart = func(i int, file hugdl.File) {
+		status("[%d/%d] Downloading %s...\n", i+1, len(files), file.Path)
+		show.frames.Start(i, file.Path, file.Size)
+	}
+	opts.OnEvent = func(i int, file hugdl.File, e hugdl.Event) {
+		printEvent(file, e, bar != nil)
+	}
+	opts.OnResult = func(i int, result hugdl.FileResult) {
+		file, plan := result.File, result.Plan
+		switch {
+		case result.Status == hugdl.StatusFailed && (plan.Action == hugdl.ActionLink || plan.Action == hugdl.ActionSkip):
+			fmt.Fprintf(errOut, "❌ Failed to link %s: %v\n", file.Path, result.Err)
+		case result.Status == hugdl.StatusFailed:
+			fmt.Fprintf(errOut, "❌ Failed to download %s: %v\n", file.Path, result.Err)
+		case plan.Action == hugdl.ActionLink:
+			status("[%d/%d] 🔗 Linked %s from %s\n", i+1, len(files), file.Path, plan.Existing)
+			skipped(file.Size)
+		case result.Status == hugdl.StatusSkipped && plan.Existing != "":
+			status("[%d/%d] ⏭️  Skipped %s (present in %s)\n", i+1, len(files), file.Path, plan.Existing)
+			skipped(file.Size)
+		case result.Status == hugdl.StatusSkipped:
+			status("[%d/%d] ⏭️  Skipped %s (%s)\n", i+1, len(files), file.Path, plan.Reason)
+			skipped(file.Size)
+		default:
+			status("✅ Downloaded %s\n", file.Path)
+		}
+		show.frames.End(i, frameResult(result.Status))
+	}
+
+	// SIGUSR1 holds back files that have not started yet until SIGUSR2
+	var gate pauseGate
+	stopPause := onPauseSignals(&gate)
+	defer stopPause()
+	opts.BeforeFile = gate.wait
+
+	report, err := hub.DownloadAll(ctx, opts)
+	if report == nil {
+		var spaceErr *hugdl.SpaceError
+		if errors.As(err, &spaceErr) {
+			fmt.Fprintf(errOut, "❌ %v (use -skip-space-check to start anyway)\n", err)
+		} else {
+			fmt.Fprintf(errOut, "❌ %v\n", err)
+		}
+		return 1
+	}
+	if hits := opts.Planner.Hasher.Cache().Hits(); hits > 0 {
+		fmt.Fprintf(out, "⚡ %d unchanged files trusted from the checksum cache\n", hits)
+	}
+
+	if bar != nil {
+		bar.Finish()
+	}
+	succeeded := report.Succeeded()
+	show.frames.Done(succeeded, len(files))
+	if dropped := show.frames.Dropped(); dropped > 0 {
+		fmt.Fprintf(out, "⚠️  %d progress updates skipped because the reader fell behind\n", dropped)
+	}
+	if show.report != nil {
+		if err := writeReport(show.report, fileReports(report)); err != nil {
+			fmt.Fprintf(out, "⚠️  Could not write JSON report: %v\n", err)
+		}
+	}
+
+	// With every file in place, an error is about the run itself, e.g. the done marker
+	if err != nil && succeeded == len(files) && report.Aborted == "" && ctx.Err() == nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return exitPartial
+	}
+
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+	fmt.Fprintf(out, "🎉 Download complete! %d/%d files downloaded successfully\n", succeeded, len(files))
+	if report.Commit != "" {
+		fmt.Fprintf(out, "🔖 Commit: %s\n", report.Commit)
+	}
+	if retries := report.Retries(); retries.Total() > 0 {
+		fmt.Fprintf(out, "🔁 %d retries: %v\n", retries.Total(), retries)
+	}
+	if len(report.Quarantined) > 0 {
+		fmt.Fprintf(out, "🧪 %d corrupt files quarantined:\n", len(report.Quarantined))
+		for _, path := range report.Quarantined {
+			fmt.Fprintf(out, "   %s\n", path)
+		}
+	}
+	for _, layout := range opts.Layouts {
+		fmt.Fprintf(out, "📁 Files saved to: %s\n", layout.ModelDir)
+	}
+	if show.fixMode {
+		if succeeded < len(files) {
+			fmt.Fprintf(errOut, "❌ %d/%d files could not be fixed\n", len(files)-succeeded, len(files))
+			return 1
+		}
+		fmt.Fprintf(out, "🔍 All %d files verified\n", len(files))
+	}
+	if report.Aborted != "" {
+		fmt.Fprintf(errOut, "🛑 Aborted: %s failed checksum verification (-abort-on-first-checksum-mismatch)\n", report.Aborted)
+		return 1
+	}
+	if err := ctx.Err(); err != nil && succeeded < len(files) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			fmt.Fprintf(errOut, "⏱️  Stopped after -timeout %s; run again to resume the remaining files\n", show.runLimit)
+		} else {
+			fmt.Fprintln(errOut, "🛑 Interrupted; run again to resume the remaining files")
+		}
+		return 1
+	}
+	if failed := len(files) - succeeded; failed > 0 {
+		fmt.Fprintf(errOut, "❌ %d/%d files failed to download\n", failed, len(files))
+		return exitStatus(succeeded, len(files))
+	}
+	return 0
+}
+
+// needsToken reports whether model is gated or private, so that downloading it
+// needs an access token
+func needsToken(ctx context.Context, model, revision string) (bool, error) {
+	details, err := hub.Details(ctx, model, revision)
+	if err != nil {
+		return false, err
+	}
+	return details.Gated != "false" || details.Private, nil
+}
 
-	// Step 3: Download all files
-	fmt.Println("\n📥 Starting downloads...")
-	fmt.Println(strings.Repeat("-", 50))
+// fileSource tells where the file list comes from: the Hub's tree listing, or a
+// manifest that is trusted only with a valid signature by key when key is set
+type fileSource struct {
+	manifestURL string
+	sigURL      string
+	key         ed25519.PublicKey
+}
 
-	successCount := 0
-	for i, file := range files {
-		fmt.Printf("[%d/%d] Downloading %s...\n", i+1, len(files), file.Path)
-		
-		if err := downloadFile(baseURL, *modelName, modelDir, file); err != nil {
-			fmt.Printf("❌ Failed to download %s: %v\n", file.Path, err)
+// listModel returns the files of model at revision from source. Tree listings are
+// counted by counter and, if cachePath is set, cached there.
+func listModel(ctx context.Context, model, revision string, source fileSource, cachePath string, counter *discoveryCounter) ([]hugdl.File, error) {
+	if source.manifestURL != "" {
+		fmt.Fprintf(out, "🔍 Reading file list from %s...\n", source.manifestURL)
+		return hub.Manifest(ctx, source.manifestURL, source.key, source.sigURL)
+	}
+	fmt.Fprintln(out, "🔍 Checking available files...")
+	files, err := hub.ListTree(ctx, model, revision, hugdl.TreeOptions{CachePath: cachePath, OnPage: counter.add})
+	counter.done()
+	return files, err
+}
+
+// verifyDirectory checks the local copy of model in dir against the repo's hashes,
+// prints each file's result and returns the exit status. Sidecar entries older than
+// maxAge are not trusted.
+func verifyDirectory(ctx context.Context, dir, stateDir, model, revision string, source fileSource, hasher *hugdl.Hasher, workers int, maxAge time.Duration) int {
+	fmt.Fprintf(out, "📦 Model: %s\n", model)
+	fmt.Fprintf(out, "📁 Verifying: %s\n", dir)
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+
+	files, err := listModel(ctx, model, revision, source, "", newDiscoveryCounter())
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ Error getting model files: %v\n", err)
+		return 1
+	}
+	// Symlink and submodule oids do not hash file content, so they cannot be verified
+	var expected []hugdl.File
+	state := hugdl.LoadState(stateDir, model, revision)
+	state.SetMaxAge(maxAge)
+	for _, file := range files {
+		if file.Type == hugdl.TypeFile {
+			// Files rewritten by -normalize-line-endings are checked against what was recorded for them
+			expected = append(expected, state.LocalFile(file))
+		}
+	}
+
+	results := hugdl.VerifyLocalFiles(dir, expected, workers, hasher)
+	if hits := hasher.Cache().Hits(); hits > 0 {
+		fmt.Fprintf(out, "⚡ %d unchanged files trusted from the checksum cache\n", hits)
+	}
+	if err := hasher.Cache().Save(); err != nil {
+		fmt.Fprintf(out, "⚠️  Could not save checksum cache: %v\n", err)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(errOut, "❌ %s: %v\n", result.File.Path, result.Err)
+			failed++
+		} else {
+			fmt.Fprintf(out, "✅ %s\n", result.File.Path)
+		}
+	}
+
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+	fmt.Fprintf(out, "🔍 Verified %d/%d files successfully\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printSelection reports what each filter of selection kept
+func printSelection(selection hugdl.Selection, result hugdl.SelectResult) {
+	if step, ok := result.Step(hugdl.StepLinks); ok && step.After < step.Before {
+		fmt.Fprintf(out, "🔗 Skipped %d symlink/submodule entries\n", step.Before-step.After)
+	}
+	if step, ok := result.Step(hugdl.StepSince); ok {
+		fmt.Fprintf(out, "🔀 %d/%d files changed since %s\n", step.After, step.Before, selection.Since)
+		if len(result.Removed) > 0 {
+			fmt.Fprintf(out, "🗑️  %d files were removed since %s (local copies are left in place):\n", len(result.Removed), selection.Since)
+			for _, path := range result.Removed {
+				fmt.Fprintf(out, "   %s\n", path)
+			}
+		}
+	}
+	if step, ok := result.Step(hugdl.StepRegexp); ok {
+		fmt.Fprintf(out, "🔎 %d/%d files match %s\n", step.After, step.Before, selection.Regexp)
+	}
+	if step, ok := result.Step(hugdl.StepGlobs); ok {
+		fmt.Fprintf(out, "🔎 %d/%d files selected by -include/-exclude\n", step.After, step.Before)
+	}
+	if step, ok := result.Step(hugdl.StepStrip); ok && step.After < step.Before {
+		fmt.Fprintf(out, "✂️  %d top-level files skipped by -strip-components %d\n", step.Before-step.After, selection.Transform.StripComponents)
+	}
+	if step, ok := result.Step(hugdl.StepLFS); ok {
+		fmt.Fprintf(out, "🔎 %d/%d files selected by LFS tracking\n", step.After, step.Before)
+	}
+	if selection.AutoQuant {
+		choice, budget := result.Quant, selection.MemoryBudget
+		if choice.Fits {
+			fmt.Fprintf(out, "🧠 Selected %s (%s) for a %s memory budget\n", choice.Label, units.FormatSize(choice.Size), units.FormatSize(budget))
 		} else {
-			fmt.Printf("✅ Downloaded %s\n", file.Path)
-			successCount++
+			fmt.Fprintf(out, "🧠 Nothing fits in %s, selected the smallest quant %s (%s)\n", units.FormatSize(budget), choice.Label, units.FormatSize(choice.Size))
 		}
 	}
+	if result.ShardErr != nil {
+		fmt.Fprintf(out, "⚠️  Could not read the shard index, ordering shards by path: %v\n", result.ShardErr)
+	}
+	if _, ok := result.Step(hugdl.StepMaxFiles); ok {
+		fmt.Fprintf(out, "✂️  Limiting to the first %d files\n", selection.MaxFiles)
+	}
+}
+
+// printExplanation prints a file's -explain decision with every reason recorded for it
+func printExplanation(file hugdl.File, decision string, reasons []string) {
+	fmt.Fprintf(out, "   💡 %s: %s (%s)\n", file.Path, decision, strings.Join(reasons, "; "))
+}
+
+// printWarning prints a problem that did not fail the run
+func printWarning(err error) {
+	msg := err.Error()
+	if msg != "" {
+		msg = strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	fmt.Fprintf(out, "⚠️  %s\n", msg)
+}
+
+// exitStatus returns the status of a run that downloaded succeeded of total files:
+// 0 when every file is in place, exitFailure when none is, exitPartial otherwise
+func exitStatus(succeeded, total int) int {
+	switch {
+	case succeeded >= total:
+		return 0
+	case succeeded == 0:
+		return exitFailure
+	}
+	return exitPartial
+}
+
+// ETA formats selectable with -progress-eta-format
+const (
+	etaDuration = "duration"
+	etaAbsolute = "absolute"
+)
+
+// etaWriter feeds the aggregate bar and shows the predicted wall-clock completion
+// time in its description. The rate only counts downloaded bytes, so skipped
+// files do not make the estimate optimistic.
+type etaWriter struct {
+	mu      sync.Mutex
+	bar     *progressbar.ProgressBar
+	desc    string
+	start   time.Time
+	written int64
+	shown   time.Time
+}
+
+func (w *etaWriter) Write(p []byte) (int, error) {
+	n, err := w.bar.Write(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written += int64(n)
+
+	now := time.Now()
+	if now.Sub(w.shown) >= time.Second {
+		w.shown = now
+		state := w.bar.State()
+		rate := float64(w.written) / now.Sub(w.start).Seconds()
+		if done, ok := completionTime(now, state.Max-state.CurrentNum, rate); ok {
+			w.bar.Describe(fmt.Sprintf("%s, done ~%s", w.desc, done.Format("15:04")))
+		}
+	}
+	return n, err
+}
+
+// onResize calls redraw whenever the terminal is resized until the returned stop
+// function is called
+func onResize(redraw func()) (stop func()) {
+	resized := make(chan os.Signal, 1)
+	signals.NotifyResize(resized)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-resized:
+				redraw()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(resized)
+		close(done)
+	}
+}
+
+// pauseGate holds back new files while a run is paused. Files already downloading
+// are not affected. It is safe for concurrent use.
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // closed on resume; nil while running
+}
+
+// pause stops new files from starting, reporting false if already paused
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed = make(chan struct{})
+	return true
+}
+
+// resume lets new files start again, reporting false if not paused
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return false
+	}
+	close(g.resumed)
+	g.resumed = nil
+	return true
+}
+
+// wait blocks while the gate is paused, returning early with ctx's error
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// onPauseSignals pauses gate on SIGUSR1 and resumes it on SIGUSR2 until the returned
+// stop function is called. Platforms without these signals never pause.
+func onPauseSignals(gate *pauseGate) (stop func()) {
+	pauses := make(chan os.Signal, 1)
+	resumes := make(chan os.Signal, 1)
+	signals.NotifyPause(pauses)
+	signals.NotifyResume(resumes)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-pauses:
+				if gate.pause() {
+					fmt.Fprintln(out, "⏸️  Paused: files in progress will finish, no new ones start until SIGUSR2")
+				}
+			case <-resumes:
+				if gate.resume() {
+					fmt.Fprintln(out, "▶️  Resumed")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(pauses)
+		signal.Stop(resumes)
+		close(done)
+	}
+}
+
+// completionTime predicts when remaining bytes finish at rate bytes per second
+func completionTime(now time.Time, remaining int64, rate float64) (time.Time, bool) {
+	if rate <= 0 {
+		return time.Time{}, false
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return now.Add(time.Duration(float64(remaining) / rate * float64(time.Second))), true
+}
+
+// fileReport is one file's result in the -json report
+type fileReport struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	Status  hugdl.FileStatus  `json:"status"`
+	Retries hugdl.RetryCounts `json:"retries,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// fileReports describes how every file of report ended
+func fileReports(report *hugdl.Report) []fileReport {
+	reports := make([]fileReport, len(report.Files))
+	for i, result := range report.Files {
+		reports[i] = fileReport{Path: result.File.Path, Size: result.File.Size, Status: result.Status, Retries: result.Retries}
+		if result.Err != nil {
+			reports[i].Error = result.Err.Error()
+		}
+	}
+	return reports
+}
+
+// reportSummary totals the -json report; Bytes counts every selected file,
+// BytesDownloaded only the files transferred in this run. Retries lists every reason,
+// also those that did not occur.
+type reportSummary struct {
+	Files           int               `json:"files"`
+	Downloaded      int               `json:"downloaded"`
+	Skipped         int               `json:"skipped"`
+	Failed          int               `json:"failed"`
+	NotStarted      int               `json:"not_started"`
+	Bytes           int64             `json:"bytes"`
+	BytesDownloaded int64             `json:"bytes_downloaded"`
+	Retries         hugdl.RetryCounts `json:"retries"`
+}
+
+// writeReport writes the -json report: every file's result followed by the totals
+func writeReport(w io.Writer, reports []fileReport) error {
+	summary := reportSummary{Files: len(reports), Retries: hugdl.RetryCounts{
+		hugdl.RetryTimeout: 0, hugdl.RetryConnection: 0, hugdl.RetryRateLimited: 0, hugdl.RetryServerError: 0,
+	}}
+	for _, report := range reports {
+		summary.Bytes += report.Size
+		for reason, n := range report.Retries {
+			summary.Retries[reason] += n
+		}
+		switch report.Status {
+		case hugdl.StatusDownloaded:
+			summary.Downloaded++
+			summary.BytesDownloaded += report.Size
+		case hugdl.StatusSkipped:
+			summary.Skipped++
+		case hugdl.StatusFailed:
+			summary.Failed++
+		default:
+			summary.NotStarted++
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Files   []fileReport  `json:"files"`
+		Summary reportSummary `json:"summary"`
+	}{reports, summary})
+}
+
+// frameResult maps a file's status to the result its progress End frame carries
+func frameResult(status hugdl.FileStatus) byte {
+	switch status {
+	case hugdl.StatusDownloaded:
+		return progress.OK
+	case hugdl.StatusSkipped:
+		return progress.Skipped
+	}
+	return progress.Failed
+}
+
+// selfTestModel is a tiny public repo used by -selftest
+const selfTestModel = "hf-internal-testing/tiny-random-bert"
+
+// runSelfTest downloads modelName into a temporary directory, verifies every file
+// against the repo's hashes and removes the directory again.
+func runSelfTest(ctx context.Context, modelName string) error {
+	fmt.Fprintf(out, "🧪 Self-test with %s\n", modelName)
+
+	tmpDir, err := os.MkdirTemp("", "hugdl-selftest-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files, err := hub.ListFiles(ctx, modelName, "main")
+	if err != nil {
+		return fmt.Errorf("listing: %w", err)
+	}
+	if len(files) == 0 {
+		return errors.New("listing: no files found")
+	}
+	fmt.Fprintf(out, "✅ Listed %d files\n", len(files))
+
+	_, err = hub.DownloadAll(ctx, hugdl.DownloadAllOptions{
+		Model:    modelName,
+		Revision: "main",
+		Dest:     tmpDir,
+		Files:    files,
+		OnEvent: func(_ int, file hugdl.File, e hugdl.Event) {
+			printEvent(file, e, false)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	fmt.Fprintf(out, "✅ Downloaded %d files\n", len(files))
+
+	for _, result := range hugdl.VerifyLocalFiles(tmpDir, files, runtime.NumCPU(), nil) {
+		if result.Err != nil {
+			return fmt.Errorf("verification of %s: %w", result.File.Path, result.Err)
+		}
+	}
+	fmt.Fprintf(out, "✅ Verified %d files\n", len(files))
+
+	return nil
+}
+
+// discoveryCounter reports how many files a listing has found so far.
+// A nil counter or one without an output writer stays silent.
+type discoveryCounter struct {
+	out   io.Writer
+	count int
+}
+
+// newDiscoveryCounter returns a counter that renders to the status output only when
+// it is a terminal
+func newDiscoveryCounter() *discoveryCounter {
+	if f, ok := out.(*os.File); ok && isTerminal(f) {
+		return &discoveryCounter{out: f}
+	}
+	return &discoveryCounter{}
+}
+
+// add records n more discovered files
+func (c *discoveryCounter) add(n int) {
+	if c == nil {
+		return
+	}
+	c.count += n
+	if c.out != nil {
+		fmt.Fprintf(c.out, "\r🔍 Discovered %d files...", c.count)
+	}
+}
 
-	fmt.Println(strings.Repeat("=", 50))
-	fmt.Printf("🎉 Download complete! %d/%d files downloaded successfully\n", successCount, len(files))
-	fmt.Printf("📁 Files saved to: %s\n", modelDir)
+// done ends the progress line
+func (c *discoveryCounter) done() {
+	if c != nil && c.out != nil && c.count > 0 {
+		fmt.Fprintln(c.out)
+	}
 }
 
-// getModelFiles fetches the list of files from HuggingFace API
-func getModelFiles(apiURL, modelName string) ([]ModelInfo, error) {
-	url := fmt.Sprintf("%s/models/%s/tree/main", apiURL, modelName)
-	
-	resp, err := http.Get(url)
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// printPlan prints the planned action for every file and the bytes that would be transferred
+func printPlan(files []hugdl.File, layouts []hugdl.Layout, planner hugdl.Planner) error {
+	fmt.Fprintln(out, "\n📝 Dry run, nothing will be written")
+	fmt.Fprintln(out, strings.Repeat("-", 50))
+
+	plans, err := planner.PlanFiles(files, layouts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch model info: %w", err)
+		return err
+	}
+	var total, transfer int64
+	counts := map[string]int{}
+	for _, plan := range plans {
+		fmt.Fprintf(out, "   %-9s %10s  %s\n", plan.Action, units.FormatSize(plan.File.Size), plan.File.Path)
+
+		counts[plan.Action]++
+		total += plan.File.Size
+		transfer += plan.Bytes
+	}
+
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+	fmt.Fprintf(out, "📦 %d files, %s total\n", len(files), units.FormatSize(total))
+	fmt.Fprintf(out, "📥 %d to download, %d to resume, %d to skip, %d to link\n", counts[hugdl.ActionDownload], counts[hugdl.ActionResume], counts[hugdl.ActionSkip], counts[hugdl.ActionLink])
+	fmt.Fprintf(out, "🌐 %s would be transferred\n", units.FormatSize(transfer))
+	return nil
+}
+
+// Formats accepted by -list-output
+const (
+	listTable = "table"
+	listJSON  = "json"
+	listCSV   = "csv"
+)
+
+// listEntry is one file in a -list-output listing; Oid is the content hash a
+// local copy should have (SHA256 for LFS files, the git blob id otherwise)
+type listEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+	LFS  bool   `json:"lfs"`
+	Oid  string `json:"oid"`
+}
+
+// listFormat returns the -list-output format: -list is table, or json together with
+// -json, unless a format was given explicitly
+func listFormat(list, jsonOut bool, format string) string {
+	if !list || format != "" {
+		return format
+	}
+	if jsonOut {
+		return listJSON
+	}
+	return listTable
+}
+
+// printListing writes files to w in the given -list-output format
+func printListing(w io.Writer, files []hugdl.File, format string) error {
+	entries := make([]listEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, listEntry{Path: file.Path, Size: file.Size, Type: file.Type, LFS: file.LFS, Oid: file.ExpectedOid()})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %d", resp.StatusCode)
+	switch format {
+	case listJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case listCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "size", "type", "lfs", "oid"})
+		for _, entry := range entries {
+			cw.Write([]string{entry.Path, strconv.FormatInt(entry.Size, 10), entry.Type, strconv.FormatBool(entry.LFS), entry.Oid})
+		}
+		cw.Flush()
+		return cw.Error()
 	}
 
-	var apiResponse []struct {
-		Type string `json:"type"`
-		Path string `json:"path"`
-		Size int64  `json:"size,omitempty"`
+	var total int64
+	for _, entry := range entries {
+		lfs := ""
+		if entry.LFS {
+			lfs = "LFS"
+		}
+		line := fmt.Sprintf("   %-50s %-9s %10s %s", entry.Path, entry.Type, units.FormatSize(entry.Size), lfs)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		total += entry.Size
 	}
+	fmt.Fprintln(w, strings.Repeat("=", 50))
+	_, err := fmt.Fprintf(w, "📦 %d files, %s total\n", len(entries), units.FormatSize(total))
+	return err
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode API response: %w", err)
+// printModelDetails writes model metadata to w in a readable block, or as JSON
+func printModelDetails(w io.Writer, details hugdl.ModelDetails, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(details)
 	}
 
-	var files []ModelInfo
-	for _, item := range apiResponse {
-		if item.Type == "file" {
-			files = append(files, ModelInfo{
-				Name: filepath.Base(item.Path),
-				Type: item.Type,
-				Size: item.Size,
-				Path: item.Path,
-			})
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
 		}
+		return s
 	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "📦 Model:     %s\n", details.ID)
+	fmt.Fprintln(&b, strings.Repeat("-", 50))
+	fmt.Fprintf(&b, "🏷️  Pipeline:  %s\n", orNone(details.PipelineTag))
+	fmt.Fprintf(&b, "📚 Library:   %s\n", orNone(details.Library))
+	fmt.Fprintf(&b, "📜 License:   %s\n", orNone(details.License))
+	fmt.Fprintf(&b, "📥 Downloads: %d\n", details.Downloads)
+	fmt.Fprintf(&b, "❤️  Likes:     %d\n", details.Likes)
+	fmt.Fprintf(&b, "🔒 Gated:     %s\n", details.Gated)
+	fmt.Fprintf(&b, "🕶️  Private:   %t\n", details.Private)
+	fmt.Fprintf(&b, "🔖 Tags:      %s\n", orNone(strings.Join(details.Tags, ", ")))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
 
-	return files, nil
+// loadGlobs combines the globs of an -include or -exclude list with those of its
+// pattern file, if any
+func loadGlobs(list, path string) ([]hugdl.Glob, error) {
+	globs, err := hugdl.ParseGlobs(list)
+	if err != nil || path == "" {
+		return globs, err
+	}
+	fromFile, err := hugdl.ReadGlobs(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(globs, fromFile...), nil
 }
 
-// downloadFile downloads a single file with simple progress
-func downloadFile(baseURL, modelName, modelDir string, file ModelInfo) error {
-	// Create download URL
-	downloadURL := fmt.Sprintf("%s/%s/resolve/main/%s", baseURL, modelName, file.Path)
-	
-	// Create output file path
-	outputPath := filepath.Join(modelDir, file.Name)
-	
-	// Create HTTP request
-	req, err := http.NewRequest("GET", downloadURL, nil)
+// parseFileMode parses a -file-perm value such as 0640 or 640
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("%q is not an octal mode", value)
+	}
+	if mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("%q is outside 0001-0777", value)
 	}
+	return os.FileMode(mode), nil
+}
+
+// out receives status messages: stdout, unless stdout carries machine-readable output
+// and they move to stderr, or -quiet discards them
+var out io.Writer = os.Stdout
 
-	// Add headers to mimic browser
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Accept", "*/*")
+// errOut receives error messages. It follows out unless -quiet discards out.
+var errOut io.Writer = os.Stdout
+
+// httpTransport is shared by every request so connection settings such as -min-tls apply everywhere
+var httpTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// httpClient is used for API calls; downloads build their own client on its Transport
+var httpClient = &http.Client{
+	Transport: httpTransport,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return hub.CheckRedirect(req, via)
+	},
+}
 
-	// Make request with timeout
-	client := &http.Client{Timeout: 30 * time.Minute}
-	resp, err := client.Do(req)
+// hub sends requests with the credentials from -token, HF_TOKEN and -endpoint-auth-map,
+// and lists and downloads files; main points it at the endpoint and httpClient
+var hub = &hugdl.Client{Endpoint: defaultEndpoint}
+
+// configureNetwork applies the TLS policy, connection reuse, proxy and credentials
+// shared by API calls and downloads
+func configureNetwork(minTLS string, noReuse bool, proxy, token, authMap string) error {
+	if minTLS != "" {
+		version, err := parseTLSVersion(minTLS)
+		if err != nil {
+			return fmt.Errorf("-min-tls: %w", err)
+		}
+		httpTransport.TLSClientConfig = &tls.Config{MinVersion: version}
+	}
+	// Some proxies corrupt responses on reused connections
+	httpTransport.DisableKeepAlives = noReuse
+
+	// API calls and downloads share httpTransport, so one proxy setting covers both
+	httpTransport.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		proxyURL, err := parseProxy(proxy)
+		if err != nil {
+			return fmt.Errorf("-proxy: %w", err)
+		}
+		httpTransport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	hub.HTTPClient = httpClient
+	hub.Token = token
+	if hub.Token == "" {
+		hub.Token = os.Getenv("HF_TOKEN")
+	}
+	if authMap != "" {
+		creds, err := loadAuthMap(authMap)
+		if err != nil {
+			return fmt.Errorf("-endpoint-auth-map: %w", err)
+		}
+		hub.Credentials = creds
+	}
+	return nil
+}
+
+// loadAuthMap reads an -endpoint-auth-map file
+func loadAuthMap(path string) (map[string]hugdl.Credentials, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+		return nil, err
+	}
+	var raw map[string]hugdl.Credentials
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	defer resp.Body.Close()
+	creds := make(map[string]hugdl.Credentials, len(raw))
+	for host, auth := range raw {
+		// Accept full endpoint URLs as keys as well as bare hosts
+		if u, err := neturl.Parse(host); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		creds[strings.ToLower(host)] = auth
+	}
+	return creds, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %d", resp.StatusCode)
+// parseEndpoint checks that endpoint is an absolute http(s) URL and returns it
+// without a trailing slash, so paths can be appended with a single "/"
+func parseEndpoint(endpoint string) (string, error) {
+	u, err := neturl.Parse(endpoint)
+	if err != nil {
+		return "", err
 	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%q must start with http:// or https://", endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q has no host", endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a query or fragment", endpoint)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
 
-	// Create output file
-	out, err := os.Create(outputPath)
+// parseProxy validates a -proxy URL. Credentials may be given as user:password@host.
+func parseProxy(value string) (*neturl.URL, error) {
+	u, err := neturl.Parse(value)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return nil, err
 	}
-	defer out.Close()
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%q must start with http://, https:// or socks5://", value)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", value)
+	}
+	return u, nil
+}
+
+// parseTLSVersion maps a -min-tls value to its crypto/tls constant
+func parseTLSVersion(value string) (uint16, error) {
+	switch value {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q (use 1.2 or 1.3)", value)
+}
+
+// printModelRefs prints each group of refs with the commit it points to
+func printModelRefs(modelName string, refs hugdl.ModelRefs) {
+	fmt.Fprintf(out, "📦 Model: %s\n", modelName)
+
+	groups := []struct {
+		title string
+		refs  []hugdl.GitRef
+	}{
+		{"🌿 Branches", refs.Branches},
+		{"🏷️  Tags", refs.Tags},
+		{"🔄 Converts", refs.Converts},
+		{"🔀 Pull requests", refs.PullRequests},
+	}
+	for _, group := range groups {
+		if len(group.refs) == 0 {
+			continue
+		}
+		fmt.Fprintln(out, strings.Repeat("-", 50))
+		fmt.Fprintln(out, group.title)
+		for _, ref := range group.refs {
+			fmt.Fprintf(out, "   %-30s %s\n", ref.Ref, ref.TargetCommit)
+		}
+	}
+}
+
+// printCached prints the models found in outputDir, optionally checking their commits against the Hub
+func printCached(ctx context.Context, outputDir string, models []hubcache.Model, checkRemote bool) {
+	fmt.Fprintf(out, "📁 %s\n", outputDir)
+	fmt.Fprintln(out, strings.Repeat("-", 50))
+
+	var total int64
+	for _, model := range models {
+		fmt.Fprintf(out, "   %-40s %-6s %5d files %10s\n", model.Name, model.Format, model.Files, units.FormatSize(model.Size))
+		total += model.Size
+
+		if !checkRemote {
+			continue
+		}
+		details, err := hub.Details(ctx, model.Name, "")
+		switch {
+		case err != nil:
+			fmt.Fprintf(out, "      ⚠️  could not check the Hub: %v\n", err)
+		case model.Commit == "":
+			fmt.Fprintf(out, "      ❔ no recorded commit (Hub is at %s)\n", details.Sha)
+		case model.Commit == details.Sha:
+			fmt.Fprintf(out, "      ✅ up to date (%s)\n", model.Commit)
+		default:
+			fmt.Fprintf(out, "      🔄 outdated: have %s, Hub is at %s\n", model.Commit, details.Sha)
+		}
+	}
+
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+	fmt.Fprintf(out, "📦 %d models, %s total\n", len(models), units.FormatSize(total))
+}
 
-	// Download with simple progress
-	fmt.Printf("   📥 Downloading %s (%d bytes)...\n", file.Name, file.Size)
-	
-	// Copy with progress
-	written, err := io.Copy(out, resp.Body)
+// printGC reports what -gc removed from outputDir, or would remove with -dry-run
+func printGC(outputDir string, budget int64, result hubcache.GCResult, dryRun bool) {
+	fmt.Fprintf(out, "🧹 %s: %d blobs, %s (budget %s)\n", outputDir, result.Blobs, units.FormatSize(result.Total), units.FormatSize(budget))
+	for _, blob := range result.Removed {
+		fmt.Fprintf(out, "   🗑️  %s (%s)\n", blob.Path, units.FormatSize(blob.Size))
+	}
+
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	fmt.Fprintf(out, "✅ %s %d blobs, %s freed; cache is %s\n", verb, len(result.Removed), units.FormatSize(result.Freed), units.FormatSize(result.Remaining()))
+	if budget > 0 && result.Remaining() > budget {
+		fmt.Fprintf(out, "⚠️  Still over budget: the remaining blobs are linked from snapshots\n")
+	}
+}
+
+// printEvent prints what a download reports about file. With a progress bar the
+// per-file start and finish lines are left out; warnings and retries are always printed.
+func printEvent(file hugdl.File, e hugdl.Event, bar bool) {
+	switch e.Kind {
+	case hugdl.EventStart:
+		switch {
+		case bar:
+		case e.Offset > 0:
+			fmt.Fprintf(out, "   ↩️  Resuming %s at %d of %d bytes...\n", file.Name(), e.Offset, file.Size)
+		default:
+			fmt.Fprintf(out, "   📥 Downloading %s (%d bytes)...\n", file.Name(), file.Size)
+		}
+	case hugdl.EventRetry:
+		fmt.Fprintf(out, "   🔁 Retrying %s in %s (attempt %d/%d): %v\n", file.Name(), e.Delay.Round(100*time.Millisecond), e.Attempt, e.Max, e.Err)
+	case hugdl.EventChecksumRetry:
+		fmt.Fprintf(out, "   🔁 %s failed verification (%v), downloading again from the start (attempt %d/%d)\n", file.Name(), e.Err, e.Attempt, e.Max)
+	case hugdl.EventSizeUnknown:
+		fmt.Fprintf(out, "   ⚠️  Could not check the remote size of %s (status %d), resuming anyway\n", file.Name(), e.Status)
+	case hugdl.EventSizeChanged:
+		fmt.Fprintf(out, "   ⚠️  Remote size of %s changed from %d to %d bytes, restarting\n", file.Name(), file.Size, e.Size)
+	case hugdl.EventRangeIgnored:
+		fmt.Fprintf(out, "   ⚠️  Server ignored the range request, restarting %s\n", file.Name())
+	case hugdl.EventResumeRejected:
+		fmt.Fprintf(out, "   ⚠️  Partial download of %s looks corrupt (%v), restarting\n", file.Name(), e.Err)
+	case hugdl.EventPreAllocateFailed:
+		fmt.Fprintf(out, "   ⚠️  Could not pre-allocate %s: %v\n", e.Path, e.Err)
+	case hugdl.EventDiskFull:
+		fmt.Fprintf(out, "   💾 Disk full while writing %s, waiting up to %s for free space...\n", file.Name(), e.Delay)
+	case hugdl.EventDone:
+		if !bar {
+			fmt.Fprintf(out, "   ✅ Downloaded %s (%d bytes)\n", file.Name(), e.Size)
+		}
+	}
+}
+
+// streamCommand is a -stream-to-command process fed one file's bytes on stdin
+type streamCommand struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  bool
+}
+
+// startStreamCommand starts command in the shell for file. The repo path is passed as
+// $1 (not on Windows) and in HUGDL_PATH, along with HUGDL_FILE (the local path),
+// HUGDL_SIZE and HUGDL_OID. The command's output goes to ours.
+func startStreamCommand(ctx context.Context, command string, file hugdl.File, localPath string) (*streamCommand, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command, "hugdl", file.Path)
+	}
+	cmd.Env = append(os.Environ(),
+		"HUGDL_PATH="+file.Path,
+		"HUGDL_FILE="+localPath,
+		"HUGDL_SIZE="+strconv.FormatInt(file.Size, 10),
+		"HUGDL_OID="+file.ExpectedOid(),
+	)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start stream command: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start stream command: %w", err)
+	}
+	return &streamCommand{cmd: cmd, stdin: stdin}, nil
+}
+
+func (c *streamCommand) Write(p []byte) (int, error) {
+	n, err := c.stdin.Write(p)
 	if err != nil {
-		return fmt.Errorf("failed to save file: %w", err)
+		return n, fmt.Errorf("stream command stopped reading its input: %w", err)
 	}
+	return n, nil
+}
 
-	fmt.Printf("   ✅ Downloaded %s (%d bytes)\n", file.Name, written)
+// Close closes the command's input and waits for it to exit successfully
+func (c *streamCommand) Close() error {
+	c.done = true
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("stream command failed: %w", err)
+	}
 	return nil
-} 
\ No newline at end of file
+}
+
+// Abort stops a command that was not finished, e.g. after a network error
+func (c *streamCommand) Abort() {
+	if c.done {
+		return
+	}
+	c.done = true
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
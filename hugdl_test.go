@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"downloader/internal/progress"
+	"downloader/pkg/hugdl"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+func TestPauseGate(t *testing.T) {
+	var gate pauseGate
+	if err := gate.wait(context.Background()); err != nil {
+		t.Fatalf("wait on a running gate: %v", err)
+	}
+	if !gate.pause() || gate.pause() {
+		t.Fatal("pause should succeed once")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := gate.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait while paused = %v, want the context's deadline", err)
+	}
+
+	started := make(chan struct{})
+	go func() {
+		gate.wait(context.Background())
+		close(started)
+	}()
+	if !gate.resume() || gate.resume() {
+		t.Fatal("resume should succeed once")
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after resume")
+	}
+}
+
+func TestDiscoveryCounter(t *testing.T) {
+	var out bytes.Buffer
+	counter := &discoveryCounter{out: &out}
+	counter.add(2)
+	counter.add(1)
+	counter.done()
+	if want := "\r🔍 Discovered 2 files...\r🔍 Discovered 3 files...\n"; out.String() != want {
+		t.Errorf("counter wrote %q, want %q", out.String(), want)
+	}
+
+	// A nil counter, and one without a terminal to write to, must be safe to use
+	var silent *discoveryCounter
+	silent.add(1)
+	silent.done()
+	(&discoveryCounter{}).add(1)
+}
+
+// serveRepo points hub at a fake Hub serving files as the repo org/tiny at main.
+// A path in corrupt is served with different content than its listed oid.
+func serveRepo(t *testing.T, files map[string]string, corrupt string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/models/org/tiny/tree/main" {
+			var items []map[string]any
+			for path, content := range files {
+				h := hugdl.NewOidHash(false, int64(len(content)))
+				h.Write([]byte(content))
+				items = append(items, map[string]any{"type": "file", "path": path, "size": len(content), "oid": hex.EncodeToString(h.Sum(nil))})
+			}
+			json.NewEncoder(w).Encode(items)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, "/org/tiny/resolve/main/")
+		content, found := files[path]
+		if !ok || !found {
+			http.NotFound(w, r)
+			return
+		}
+		if path == corrupt {
+			content = strings.ToUpper(content)
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	saved := hub
+	hub = &hugdl.Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	t.Cleanup(func() { hub = saved })
+}
+
+func TestSelfTest(t *testing.T) {
+	files := map[string]string{"config.json": `{"a":1}`, "vocab.txt": "pad\nunk\n"}
+	serveRepo(t, files, "")
+	if err := runSelfTest(context.Background(), "org/tiny"); err != nil {
+		t.Errorf("self-test against a healthy Hub: %v", err)
+	}
+
+	serveRepo(t, files, "vocab.txt")
+	if err := runSelfTest(context.Background(), "org/tiny"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("self-test with a corrupt download = %v, want a checksum failure", err)
+	}
+	if err := runSelfTest(context.Background(), "org/missing"); err == nil || !strings.Contains(err.Error(), "listing") {
+		t.Errorf("self-test of a missing model = %v, want a listing failure", err)
+	}
+}
+
+// resetNetwork restores the transport and hub settings configureNetwork changes
+func resetNetwork(t *testing.T) {
+	transport := httpTransport.Clone()
+	saved := *hub
+	t.Cleanup(func() {
+		httpTransport.TLSClientConfig = transport.TLSClientConfig
+		httpTransport.DisableKeepAlives = transport.DisableKeepAlives
+		httpTransport.Proxy = transport.Proxy
+		*hub = saved
+	})
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	resetNetwork(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	for version, wantErr := range map[string]bool{"1.2": false, "1.3": true} {
+		if err := configureNetwork(version, false, "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		transport := httpTransport.Clone()
+		transport.TLSClientConfig.RootCAs = roots
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != wantErr {
+			t.Errorf("-min-tls %s against a TLS 1.2 server: %v, want failure %v", version, err, wantErr)
+		}
+	}
+	if err := configureNetwork("1.1", false, "", "", ""); err == nil || !strings.Contains(err.Error(), "-min-tls") {
+		t.Errorf("configureNetwork accepted TLS 1.1: %v", err)
+	}
+}
+
+func TestDisableKeepAlive(t *testing.T) {
+	resetNetwork(t)
+	for noReuse, want := range map[bool]int32{false: 1, true: 3} {
+		var connections atomic.Int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}))
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections.Add(1)
+			}
+		}
+		server.Start()
+
+		if err := configureNetwork("", noReuse, "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			resp, err := httpClient.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		server.Close()
+		if got := connections.Load(); got != want {
+			t.Errorf("-disable-keepalive=%v: 3 requests opened %d connections, want %d", noReuse, got, want)
+		}
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	for value, want := range map[string]os.FileMode{"0640": 0640, "640": 0640, "0444": 0444, "777": 0777} {
+		if got, err := parseFileMode(value); err != nil || got != want {
+			t.Errorf("parseFileMode(%q) = %04o, %v; want %04o", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "0", "0999", "1777", "rw-r--r--", "-644"} {
+		if got, err := parseFileMode(value); err == nil {
+			t.Errorf("parseFileMode(%q) = %04o, want an error", value, got)
+		}
+	}
+}
+
+func TestCompactProgress(t *testing.T) {
+	tests := []struct {
+		totalOnly, explicit, quiet bool
+		eta                        string
+		files                      int
+		terminal                   bool
+		want                       bool
+	}{
+		{false, false, false, etaDuration, 3, true, true},   // several files to a terminal
+		{false, false, false, etaDuration, 1, true, false},  // a single file keeps its own bar
+		{false, false, false, etaDuration, 3, false, false}, // logs keep the per-file messages
+		{false, false, false, etaAbsolute, 1, false, true},  // the completion time needs the total bar
+		{true, true, false, etaDuration, 1, false, true},
+		{false, true, false, etaDuration, 3, true, false},
+		{true, true, true, etaDuration, 3, true, false}, // -quiet wins
+	}
+	for _, tt := range tests {
+		if got := compactProgress(tt.totalOnly, tt.explicit, tt.quiet, tt.eta, tt.files, tt.terminal); got != tt.want {
+			t.Errorf("compactProgress(%+v) = %v, want %v", tt, got, tt.want)
+		}
+	}
+}
+
+func TestCompletionTime(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		remaining int64
+		rate      float64
+		want      time.Time
+		ok        bool
+	}{
+		{3600 * 1000, 1000, now.Add(time.Hour), true},
+		{500, 1000, now.Add(500 * time.Millisecond), true},
+		{-10, 1000, now, true}, // the bar ran past its total
+		{1000, 0, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := completionTime(now, tt.remaining, tt.rate)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("completionTime(%d, %v) = %v, %v; want %v, %v", tt.remaining, tt.rate, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// The aggregate bar's description names the predicted wall-clock time
+	bar := progressbar.NewOptions64(4000, progressbar.OptionSetWriter(io.Discard))
+	w := &etaWriter{bar: bar, desc: "📦 2 files", start: time.Now().Add(-time.Second)}
+	w.Write(make([]byte, 1000))
+	desc := bar.State().Description
+	if !strings.HasPrefix(desc, "📦 2 files, done ~") {
+		t.Fatalf("description = %q, want a completion time", desc)
+	}
+	if _, err := time.Parse("15:04", strings.TrimPrefix(desc, "📦 2 files, done ~")); err != nil {
+		t.Errorf("completion time in %q is not HH:MM: %v", desc, err)
+	}
+}
+
+func TestVerifyAndFix(t *testing.T) {
+	files := map[string]string{"config.json": `{"a":1}`, "vocab.txt": "pad\nunk\n"}
+	dir := t.TempDir()
+	// A same-size edit is only found by hashing; the missing file is downloaded
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"a":2}`), 0644)
+	fix := func() int {
+		t.Helper()
+		listed, err := hub.ListFiles(context.Background(), "org/tiny", "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return download(context.Background(), hugdl.DownloadAllOptions{
+			Model:    "org/tiny",
+			Revision: "main",
+			Files:    listed,
+			Layouts:  []hugdl.Layout{{Format: hugdl.LayoutNested, ModelDir: dir, Revision: "main"}},
+			Planner:  hugdl.Planner{DeepSync: true},
+		}, display{fixMode: true})
+	}
+
+	serveRepo(t, files, "")
+	if status := fix(); status != 0 {
+		t.Errorf("fixing a damaged copy exited %d, want 0", status)
+	}
+	for path, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(dir, path)); string(got) != content {
+			t.Errorf("%s = %q after the fix, want %q", path, got, content)
+		}
+	}
+
+	// A file that cannot be fixed fails the run
+	os.Remove(filepath.Join(dir, "vocab.txt"))
+	serveRepo(t, files, "vocab.txt")
+	if status := fix(); status != 1 {
+		t.Errorf("a fix that left a corrupt file exited %d, want 1", status)
+	}
+}
+
+func TestJSONReportOwnsItsWriter(t *testing.T) {
+	serveRepo(t, map[string]string{"config.json": `{"a":1}`}, "")
+	listed, err := hub.ListFiles(context.Background(), "org/tiny", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Status messages go to out and the report to its own writer, without touching os.Stdout
+	stdout := os.Stdout
+	var status, data bytes.Buffer
+	saved := out
+	out = &status
+	defer func() { out = saved }()
+	code := download(context.Background(), hugdl.DownloadAllOptions{
+		Model:    "org/tiny",
+		Revision: "main",
+		Files:    listed,
+		Layouts:  []hugdl.Layout{{Format: hugdl.LayoutNested, ModelDir: t.TempDir(), Revision: "main"}},
+	}, display{report: &data})
+	if code != 0 {
+		t.Fatalf("download exited %d", code)
+	}
+	if os.Stdout != stdout {
+		t.Error("download replaced os.Stdout")
+	}
+	var report struct {
+		Files []map[string]any `json:"files"`
+	}
+	if err := json.Unmarshal(data.Bytes(), &report); err != nil || len(report.Files) != 1 {
+		t.Errorf("report = %q, %v; want JSON for one file", data.String(), err)
+	}
+	if !strings.Contains(status.String(), "Starting downloads") || strings.Contains(status.String(), `"files"`) {
+		t.Errorf("status output = %q, want the status messages without the report", status.String())
+	}
+}
+
+func TestNeedsToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/models/org/open/revision/main":
+			fmt.Fprint(w, `{"id":"org/open","gated":false,"private":false}`)
+		case "/api/models/org/gated/revision/main":
+			fmt.Fprint(w, `{"id":"org/gated","gated":"manual","private":false}`)
+		case "/api/models/org/private/revision/main":
+			fmt.Fprint(w, `{"id":"org/private","gated":false,"private":true}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	saved := hub
+	hub = &hugdl.Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	defer func() { hub = saved }()
+
+	for model, want := range map[string]bool{"org/open": false, "org/gated": true, "org/private": true} {
+		if got, err := needsToken(context.Background(), model, "main"); err != nil || got != want {
+			t.Errorf("needsToken(%s) = %v, %v; want %v", model, got, err, want)
+		}
+	}
+	if _, err := needsToken(context.Background(), "org/missing", "main"); err == nil {
+		t.Error("needsToken of a missing model succeeded")
+	}
+}
+
+func TestModelDetails(t *testing.T) {
+	details := hugdl.ModelDetails{ID: "org/m", Sha: "c0ffee", PipelineTag: "text-generation", Library: "transformers", License: "mit",
+		Downloads: 12, Likes: 3, Tags: []string{"gguf", "license:mit"}, Gated: "manual"}
+
+	var text bytes.Buffer
+	if err := printModelDetails(&text, details, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"📦 Model:     org/m\n", "📜 License:   mit\n", "🔒 Gated:     manual\n", "🔖 Tags:      gguf, license:mit\n"} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("text output lacks %q:\n%s", line, text.String())
+		}
+	}
+	var asJSON bytes.Buffer
+	if err := printModelDetails(&asJSON, details, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded hugdl.ModelDetails
+	if err := json.Unmarshal(asJSON.Bytes(), &decoded); err != nil || fmt.Sprint(decoded) != fmt.Sprint(details) {
+		t.Errorf("JSON output %s decodes to %+v, %v", asJSON.String(), decoded, err)
+	}
+
+	// A closed stdout must fail the command rather than print nothing and exit 0
+	if err := printModelDetails(failingWriter{}, details, true); err == nil {
+		t.Error("printModelDetails ignored a write error")
+	}
+}
+
+// failingWriter fails every write, like a closed pipe
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		succeeded, total, want int
+	}{
+		{4, 4, 0},
+		{0, 0, 0},
+		{3, 4, exitPartial},
+		{0, 4, exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitStatus(tt.succeeded, tt.total); got != tt.want {
+			t.Errorf("exitStatus(%d, %d) = %d, want %d", tt.succeeded, tt.total, got, tt.want)
+		}
+	}
+}
+
+// listingFiles is a small selection for the -list-output tests
+var listingFiles = []hugdl.File{
+	{Type: hugdl.TypeFile, Path: "config.json", Size: 42, Oid: "c0de"},
+	{Type: hugdl.TypeFile, Path: "onnx/model, fp16.onnx", Size: 3 << 20, Oid: "0ff5e7", LFS: true, LFSOid: "5ha256"},
+}
+
+func TestListingCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := printListing(&out, listingFiles, listCSV); err != nil {
+		t.Fatal(err)
+	}
+	want := "path,size,type,lfs,oid\n" +
+		"config.json,42,file,false,c0de\n" +
+		"\"onnx/model, fp16.onnx\",3145728,file,true,5ha256\n"
+	if out.String() != want {
+		t.Errorf("CSV listing:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := printListing(&out, listingFiles, listTable); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], "LFS") || !strings.Contains(lines[3], "2 files") {
+		t.Errorf("table listing:\n%s", out.String())
+	}
+}
+
+func TestListingJSON(t *testing.T) {
+	tests := []struct {
+		list, jsonOut bool
+		format, want  string
+	}{
+		{false, false, "", ""},
+		{false, true, "", ""}, // -json alone reports downloads, not a listing
+		{true, false, "", listTable},
+		{true, true, "", listJSON},
+		{true, true, listCSV, listCSV},
+		{false, false, listJSON, listJSON},
+	}
+	for _, tt := range tests {
+		if got := listFormat(tt.list, tt.jsonOut, tt.format); got != tt.want {
+			t.Errorf("listFormat(%v, %v, %q) = %q, want %q", tt.list, tt.jsonOut, tt.format, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printListing(&out, listingFiles, listJSON); err != nil {
+		t.Fatal(err)
+	}
+	var entries []listEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("-list -json printed invalid JSON: %v\n%s", err, out.String())
+	}
+	want := []listEntry{
+		{Path: "config.json", Size: 42, Type: "file", Oid: "c0de"},
+		{Path: "onnx/model, fp16.onnx", Size: 3 << 20, Type: "file", LFS: true, Oid: "5ha256"},
+	}
+	if fmt.Sprint(entries) != fmt.Sprint(want) {
+		t.Errorf("JSON listing = %+v, want %+v", entries, want)
+	}
+
+	// An empty selection is an empty array, not null
+	out.Reset()
+	printListing(&out, nil, listJSON)
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("empty JSON listing = %q", out.String())
+	}
+}
+
+func TestReportRetries(t *testing.T) {
+	report := &hugdl.Report{Files: []hugdl.FileResult{
+		{File: hugdl.File{Path: "a.bin", Size: 10}, Status: hugdl.StatusDownloaded, Retries: hugdl.RetryCounts{hugdl.RetryTimeout: 2, hugdl.RetryRateLimited: 1}},
+		{File: hugdl.File{Path: "b.bin", Size: 5}, Status: hugdl.StatusFailed, Err: errors.New("status 503"), Retries: hugdl.RetryCounts{hugdl.RetryServerError: 3}},
+		{File: hugdl.File{Path: "c.bin", Size: 1}, Status: hugdl.StatusSkipped},
+	}}
+	var out bytes.Buffer
+	if err := writeReport(&out, fileReports(report)); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Files   []map[string]any `json:"files"`
+		Summary reportSummary    `json:"summary"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := hugdl.RetryCounts{hugdl.RetryTimeout: 2, hugdl.RetryConnection: 0, hugdl.RetryRateLimited: 1, hugdl.RetryServerError: 3}
+	if fmt.Sprint(decoded.Summary.Retries) != fmt.Sprint(want) {
+		t.Errorf("summary retries = %v, want %v", decoded.Summary.Retries, want)
+	}
+	if fmt.Sprint(decoded.Files[1]["retries"]) != "map[server_error:3]" {
+		t.Errorf("b.bin retries = %v", decoded.Files[1]["retries"])
+	}
+	// Files that were never retried leave the field out
+	if _, ok := decoded.Files[2]["retries"]; ok {
+		t.Errorf("c.bin has retries: %v", decoded.Files[2])
+	}
+}
+
+func TestPatternFiles(t *testing.T) {
+	dir := t.TempDir()
+	includeFile := filepath.Join(dir, "include.txt")
+	excludeFile := filepath.Join(dir, "exclude.txt")
+	os.WriteFile(includeFile, []byte("# quantizations we ship\n*Q4_K_M*\n*Q8_0*\n"), 0644)
+	os.WriteFile(excludeFile, []byte("# never the split shards\n*-0000?-of-*\n"), 0644)
+
+	var selection hugdl.Selection
+	var err error
+	if selection.Include, err = loadGlobs("*.json", includeFile); err != nil {
+		t.Fatal(err)
+	}
+	if selection.Exclude, err = loadGlobs("", excludeFile); err != nil {
+		t.Fatal(err)
+	}
+	var files []hugdl.File
+	for _, path := range []string{"config.json", "model-Q4_K_M.gguf", "model-Q8_0-00001-of-00002.gguf", "model-Q5_K_S.gguf", "README.md"} {
+		files = append(files, hugdl.File{Type: hugdl.TypeFile, Path: path})
+	}
+	result, err := selection.Apply(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, file := range result.Files {
+		kept = append(kept, file.Path)
+	}
+	if fmt.Sprint(kept) != "[config.json model-Q4_K_M.gguf]" {
+		t.Errorf("kept %v", kept)
+	}
+
+	if _, err := loadGlobs("*.json", filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("loadGlobs accepted a missing pattern file")
+	}
+}
+
+// stalledReader is a progress consumer that never reads
+type stalledReader struct{ release chan struct{} }
+
+func (r stalledReader) Write(p []byte) (int, error) {
+	<-r.release
+	return len(p), nil
+}
+
+func TestDownloadWithStalledProgressReader(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("shard-%03d.bin", i)] = strings.Repeat("x", 1000+i)
+	}
+	serveRepo(t, files, "")
+	listed, err := hub.ListFiles(context.Background(), "org/tiny", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := stalledReader{release: make(chan struct{})}
+	defer close(reader.release)
+	frames := progress.New(reader, time.Nanosecond, nil)
+
+	dir := t.TempDir()
+	done := make(chan int)
+	go func() {
+		done <- download(context.Background(), hugdl.DownloadAllOptions{
+			Model:    "org/tiny",
+			Revision: "main",
+			Files:    listed,
+			Layouts:  []hugdl.Layout{{Format: hugdl.LayoutNested, ModelDir: dir, Revision: "main"}},
+		}, display{frames: frames})
+	}()
+	select {
+	case status := <-done:
+		if status != 0 {
+			t.Errorf("download exited %d, want 0", status)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("a stalled progress reader held up the downloads")
+	}
+	for path, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(dir, path)); string(got) != content {
+			t.Errorf("%s was not downloaded", path)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"downloader/pkg/hugdl"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitPaused polls until gate holds back new files or the deadline passes
+func waitPaused(t *testing.T, gate *pauseGate) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := gate.wait(ctx)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+	t.Fatal("SIGUSR1 did not pause the run")
+}
+
+func TestPauseSignals(t *testing.T) {
+	var gate pauseGate
+	stop := onPauseSignals(&gate)
+	defer stop()
+
+	if err := unix.Kill(os.Getpid(), unix.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitPaused(t, &gate)
+
+	// No new file starts while paused
+	started := make(chan struct{})
+	go func() {
+		gate.wait(context.Background())
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("a file started while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := unix.Kill(os.Getpid(), unix.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGUSR2 did not resume the run")
+	}
+}
+
+func TestStreamCommand(t *testing.T) {
+	dir := t.TempDir()
+	file := hugdl.File{Type: hugdl.TypeFile, Path: "onnx/model.onnx", Size: 11, Oid: "abc123"}
+	command := `cat > "$OUT_DIR/body"; echo "$1 $HUGDL_PATH $HUGDL_FILE $HUGDL_SIZE $HUGDL_OID" > "$OUT_DIR/env"`
+	t.Setenv("OUT_DIR", dir)
+
+	stream, err := startStreamCommand(context.Background(), command, file, "/out/model.onnx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stream, "hello ")
+	io.WriteString(stream, "world")
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream.Abort() // a finished command is left alone
+
+	if got, _ := os.ReadFile(filepath.Join(dir, "body")); string(got) != "hello world" {
+		t.Errorf("command read %q", got)
+	}
+	want := "onnx/model.onnx onnx/model.onnx /out/model.onnx 11 abc123\n"
+	if got, _ := os.ReadFile(filepath.Join(dir, "env")); string(got) != want {
+		t.Errorf("command saw %q, want %q", got, want)
+	}
+
+	// A command that fails fails the file
+	stream, err = startStreamCommand(context.Background(), "cat >/dev/null; exit 3", file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err == nil {
+		t.Error("a command exiting with 3 was reported as successful")
+	}
+
+	// Aborting does not wait for the command to finish reading
+	stream, err = startStreamCommand(context.Background(), "sleep 30", file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	stream.Abort()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Abort took %v", elapsed)
+	}
+}
+
+func TestOnResize(t *testing.T) {
+	redrawn := make(chan struct{}, 1)
+	stop := onResize(func() {
+		select {
+		case redrawn <- struct{}{}:
+		default:
+		}
+	})
+
+	if err := unix.Kill(os.Getpid(), unix.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-redrawn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGWINCH did not redraw the progress bar")
+	}
+
+	stop()
+	if err := unix.Kill(os.Getpid(), unix.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-redrawn:
+		t.Error("redrawn after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
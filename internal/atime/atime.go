@@ -0,0 +1,11 @@
+// Package atime reads when a file was last accessed on each supported platform.
+package atime
+
+import "time"
+
+// Of returns the last access time of the file at path. Filesystems mounted with
+// noatime or relatime update it rarely or never, so it is a lower bound at best.
+// Platforms without access times report the modification time instead.
+func Of(path string) (time.Time, error) {
+	return of(path)
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package atime
+
+import (
+	"os"
+	"time"
+)
+
+func of(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
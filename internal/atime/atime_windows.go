@@ -0,0 +1,20 @@
+//go:build windows
+
+package atime
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func of(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.LastAccessTime.Nanoseconds()), nil
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// FALLOC_FL_KEEP_SIZE reserves the blocks without moving end-of-file
+func allocate(f *os.File, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("%w: the filesystem does not support fallocate", errors.ErrUnsupported)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !windows
+
+package diskspace
+
+import "os"
+
+// Without a portable way to reserve blocks, extend the file instead; on filesystems
+// with sparse files this only sets the length
+func allocate(f *os.File, size int64) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() >= size {
+		return nil
+	}
+	return f.Truncate(size)
+}
@@ -0,0 +1,38 @@
+// Package diskspace reports the free space of a volume and reserves space for files
+// on each supported platform.
+package diskspace
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Available returns the bytes available to the current user on the volume that
+// holds path. Missing trailing directories are skipped, so path may name an
+// output directory that has not been created yet.
+func Available(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return available(path)
+}
+
+// Allocate reserves disk blocks for the first size bytes of f, so a volume that is
+// too small fails now rather than part-way through writing. On Linux and Windows the
+// file's length is left alone, so it still tells how much has been written. Elsewhere
+// f is extended to size, which reserves nothing on filesystems with sparse files.
+// Filesystems that cannot reserve space return an error wrapping errors.ErrUnsupported.
+func Allocate(f *os.File, size int64) error {
+	return allocate(f, size)
+}
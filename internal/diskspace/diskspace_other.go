@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package diskspace
+
+import (
+	"errors"
+	"runtime"
+)
+
+func available(path string) (uint64, error) {
+	return 0, errors.New("free space is not available on " + runtime.GOOS)
+}
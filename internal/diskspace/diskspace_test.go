@@ -0,0 +1,93 @@
+package diskspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAvailable(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Available(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Fatalf("Available(%s) = 0", dir)
+	}
+
+	// A directory that does not exist yet is measured on the volume that will hold it
+	missing, err := Available(filepath.Join(dir, "not", "created", "yet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing == 0 {
+		t.Error("Available of a missing directory = 0")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "file.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	const size = 1 << 20
+	if err := Allocate(f, size); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("filesystem cannot reserve space: %v", err)
+		}
+		t.Fatal(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The length still tells how much was written where space can be reserved without it
+	want := int64(size)
+	if runtime.GOOS == "linux" || runtime.GOOS == "windows" {
+		want = 3
+	}
+	if stat.Size() != want {
+		t.Errorf("size after Allocate = %d, want %d", stat.Size(), want)
+	}
+}
+
+func TestAllocateTooLarge(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("extending a file reserves nothing here")
+	}
+	dir := t.TempDir()
+	free, err := Available(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "file.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Twice the free space also exceeds blocks reserved for the superuser
+	err = Allocate(f, int64(2*free+1<<30))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("filesystem cannot reserve space: %v", err)
+	}
+	if err == nil {
+		t.Fatalf("Allocate of %d bytes with %d free succeeded", 2*free+1<<30, free)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 0 {
+		t.Errorf("size after a failed Allocate = %d, want 0", stat.Size())
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package diskspace
+
+import "golang.org/x/sys/windows"
+
+func available(path string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
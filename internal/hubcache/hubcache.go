@@ -0,0 +1,274 @@
+// Package hubcache inspects and maintains the output directories hugdl downloads
+// into: listing the models there, exporting hub cache snapshots and collecting
+// unreferenced blobs.
+package hubcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"downloader/internal/atime"
+	"downloader/pkg/hugdl"
+)
+
+// Model summarizes one model directory found by Scan
+type Model struct {
+	Name   string
+	Dir    string
+	Format string
+	Files  int
+	Size   int64
+	Commit string
+}
+
+// Scan lists the model directories under outputDir. Hub repos are recognised by
+// their models-- prefix and measured by their blobs; other directories by their files,
+// leaving out hugdl's sidecars. The model name and commit come from the download state.
+func Scan(outputDir string) ([]Model, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []Model
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		model := Model{Name: entry.Name(), Dir: filepath.Join(outputDir, entry.Name()), Format: hugdl.LayoutNested}
+		root := model.Dir
+		if rest, ok := strings.CutPrefix(entry.Name(), "models--"); ok {
+			model.Name = strings.Replace(rest, "--", "/", 1)
+			model.Format = hugdl.LayoutHub
+			root = filepath.Join(model.Dir, "blobs")
+		}
+
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && d.Name() == "quarantine" && filepath.Dir(path) == root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".hugdl-") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			model.Files++
+			model.Size += info.Size()
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		var state hugdl.State
+		if data, err := os.ReadFile(filepath.Join(model.Dir, hugdl.StateFileName)); err == nil && json.Unmarshal(data, &state) == nil {
+			if state.Model != "" {
+				model.Name = state.Model
+			}
+			model.Commit = state.Commit
+		}
+		if model.Files > 0 {
+			models = append(models, model)
+		}
+	}
+	return models, nil
+}
+
+// Export copies the snapshot of revision from the hub cache under cacheDir into
+// destDir, following symlinks so the destination holds independent regular files.
+// revision may be a ref recorded under refs/ or a commit hash.
+func Export(cacheDir, modelName, revision, destDir string) (int, error) {
+	repoDir := filepath.Join(cacheDir, "models--"+strings.ReplaceAll(modelName, "/", "--"))
+	commit := revision
+	if ref, err := os.ReadFile(filepath.Join(repoDir, "refs", filepath.FromSlash(revision))); err == nil {
+		commit = strings.TrimSpace(string(ref))
+	}
+	snapshot := filepath.Join(repoDir, "snapshots", commit)
+	if _, err := os.Stat(snapshot); err != nil {
+		return 0, fmt.Errorf("no snapshot of %s@%s in %s", modelName, revision, cacheDir)
+	}
+
+	copied := 0
+	err := filepath.WalkDir(snapshot, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(snapshot, path)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(path, filepath.Join(destDir, rel)); err != nil {
+			return fmt.Errorf("%s: %w", filepath.ToSlash(rel), err)
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
+
+// copyFile writes the content src refers to, following symlinks, to a new regular file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// Blob is one content-addressed file in a hub cache
+type Blob struct {
+	Path       string
+	Size       int64
+	LastUsed   time.Time
+	Referenced bool // linked from a snapshot
+}
+
+// collectBlobs lists the blobs of every hub repo under outputDir. A blob is referenced
+// when any snapshot links to it, by symlink or hardlink, including snapshots checked out
+// by commit hash that no ref names.
+func collectBlobs(outputDir string) ([]Blob, error) {
+	repos, err := filepath.Glob(filepath.Join(outputDir, "models--*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []Blob
+	for _, repo := range repos {
+		var linked []os.FileInfo
+		filepath.WalkDir(filepath.Join(repo, "snapshots"), func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if info, err := os.Stat(path); err == nil {
+					linked = append(linked, info)
+				}
+			}
+			return nil
+		})
+
+		entries, err := os.ReadDir(filepath.Join(repo, "blobs"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			blob := Blob{Path: filepath.Join(repo, "blobs", entry.Name()), Size: info.Size(), LastUsed: info.ModTime()}
+			if used, err := atime.Of(blob.Path); err == nil && used.After(blob.LastUsed) {
+				blob.LastUsed = used
+			}
+			for _, ref := range linked {
+				if os.SameFile(info, ref) {
+					blob.Referenced = true
+					break
+				}
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
+
+// GCResult describes what GC removed, or would remove in a dry run
+type GCResult struct {
+	Blobs   int    // blobs in the cache before collection
+	Total   int64  // their combined size
+	Removed []Blob // least recently used first
+	Freed   int64
+}
+
+// Remaining returns the size of the cache after collection
+func (r GCResult) Remaining() int64 {
+	return r.Total - r.Freed
+}
+
+// GC removes the least recently used unreferenced blobs under outputDir until the
+// cache fits maxSize, then drops snapshot entries left dangling. A blob was last used
+// when it was last read or written. Referenced blobs are kept even if the cache stays
+// over budget. With dryRun nothing is removed.
+func GC(outputDir string, maxSize int64, dryRun bool) (GCResult, error) {
+	blobs, err := collectBlobs(outputDir)
+	if err != nil {
+		return GCResult{}, err
+	}
+
+	result := GCResult{Blobs: len(blobs)}
+	for _, blob := range blobs {
+		result.Total += blob.Size
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].LastUsed.Before(blobs[j].LastUsed) })
+
+	for _, blob := range blobs {
+		if result.Remaining() <= maxSize {
+			break
+		}
+		if blob.Referenced {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(blob.Path); err != nil {
+				return result, err
+			}
+		}
+		result.Removed = append(result.Removed, blob)
+		result.Freed += blob.Size
+	}
+
+	if !dryRun && len(result.Removed) > 0 {
+		repos, _ := filepath.Glob(filepath.Join(outputDir, "models--*"))
+		for _, repo := range repos {
+			pruneSnapshots(filepath.Join(repo, "snapshots"))
+		}
+	}
+	return result, nil
+}
+
+// pruneSnapshots removes snapshot symlinks whose blob is gone and the directories they leave empty
+func pruneSnapshots(dir string) {
+	var dirs []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			os.Remove(path)
+		}
+		return nil
+	})
+	// Deepest directories first so parents can become empty
+	for i := len(dirs) - 1; i > 0; i-- {
+		os.Remove(dirs[i]) // fails harmlessly unless empty
+	}
+}
@@ -0,0 +1,132 @@
+package hubcache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes content to name under dir, creating parent directories
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGCKeepsLinkedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "models--org--m")
+	old := time.Now().Add(-48 * time.Hour)
+	blobs := map[string]time.Time{
+		"linked":    old.Add(-time.Hour), // oldest, but a snapshot without a ref uses it
+		"stale":     old,
+		"recent":    old.Add(time.Hour),
+		"untouched": time.Now(),
+	}
+	for name, used := range blobs {
+		path := writeTestFile(t, repo, "blobs/"+name, []byte(name))
+		if err := os.Chtimes(path, used, old.Add(-2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := filepath.Join(repo, "snapshots", strings.Repeat("c", 40))
+	if err := os.MkdirAll(snapshot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "..", "blobs", "linked"), filepath.Join(snapshot, "config.json")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	// Removing one unreferenced blob fits the budget; it must be the least recently used
+	var total int64
+	for name := range blobs {
+		total += int64(len(name))
+	}
+	result, err := GC(dir, total-1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 4 || result.Total != total || len(result.Removed) != 1 || result.Freed != int64(len("stale")) {
+		t.Errorf("GC = %+v, want one of 4 blobs removed, freeing %d bytes", result, len("stale"))
+	}
+	for name, want := range map[string]bool{"linked": true, "stale": false, "recent": true, "untouched": true} {
+		_, err := os.Stat(filepath.Join(repo, "blobs", name))
+		if got := err == nil; got != want {
+			t.Errorf("blob %s kept = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	// A nested download with its sidecars and quarantine, which are not model files; the
+	// repo's own index.json is
+	writeTestFile(t, dir, "org_m/config.json", []byte(`{"a":1}`))
+	writeTestFile(t, dir, "org_m/onnx/model.onnx", []byte("weights"))
+	writeTestFile(t, dir, "org_m/index.json", []byte(`{}`))
+	writeTestFile(t, dir, "org_m/.hugdl-state.json", []byte(`{"model":"org/m","revision":"main","commit":"c0ffee","files":{}}`))
+	writeTestFile(t, dir, "org_m/.hugdl-index.json", []byte(`{}`))
+	writeTestFile(t, dir, "org_m/quarantine/config.json", []byte(`{"a":2}`))
+	// A hub repo measured by its blobs, and an empty directory
+	writeTestFile(t, dir, "models--org--tiny/blobs/abc", []byte("blob"))
+	writeTestFile(t, dir, "models--org--tiny/snapshots/beef/config.json", []byte("blob"))
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+
+	models, err := Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Model{
+		{Name: "org/tiny", Dir: filepath.Join(dir, "models--org--tiny"), Format: "hub", Files: 1, Size: 4},
+		{Name: "org/m", Dir: filepath.Join(dir, "org_m"), Format: "nested", Files: 3, Size: 16, Commit: "c0ffee"},
+	}
+	if fmt.Sprint(models) != fmt.Sprint(want) {
+		t.Errorf("Scan = %+v, want %+v", models, want)
+	}
+	if _, err := Scan(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Scan of a missing directory succeeded")
+	}
+}
+
+func TestExport(t *testing.T) {
+	cache, dest := t.TempDir(), t.TempDir()
+	repo := filepath.Join(cache, "models--org--m")
+	writeTestFile(t, repo, "blobs/abc", []byte("weights"))
+	writeTestFile(t, repo, "refs/main", []byte("c0ffee\n"))
+	snapshot := filepath.Join(repo, "snapshots", "c0ffee", "onnx")
+	os.MkdirAll(snapshot, 0755)
+	if err := os.Symlink(filepath.Join("..", "..", "..", "blobs", "abc"), filepath.Join(snapshot, "model.onnx")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	// The ref is resolved and the symlink replaced by an independent copy of the blob
+	n, err := Export(cache, "org/m", "main", dest)
+	if err != nil || n != 1 {
+		t.Fatalf("Export = %d, %v; want 1 file", n, err)
+	}
+	exported := filepath.Join(dest, "onnx", "model.onnx")
+	info, err := os.Lstat(exported)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("exported file is not a regular file: %v, %v", info, err)
+	}
+	os.WriteFile(filepath.Join(repo, "blobs", "abc"), []byte("changed"), 0644)
+	if got, _ := os.ReadFile(exported); string(got) != "weights" {
+		t.Errorf("exported content = %q, want an independent copy", got)
+	}
+
+	if n, err := Export(cache, "org/m", "c0ffee", t.TempDir()); err != nil || n != 1 {
+		t.Errorf("Export by commit = %d, %v", n, err)
+	}
+	if _, err := Export(cache, "org/m", "v2", t.TempDir()); err == nil {
+		t.Error("Export of an unknown revision succeeded")
+	}
+}
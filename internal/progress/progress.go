@@ -0,0 +1,223 @@
+// Package progress implements the binary progress stream written by
+// -progress-callback-binary for embedding applications.
+package progress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Binary progress protocol written by -progress-callback-binary. Every frame is a
+// big-endian uint32 payload length followed by the payload; the payload's first
+// byte is the frame type and the rest is big-endian:
+//
+//	Start  uint32 file index, int64 size, uint16 path length, UTF-8 path
+//	Bytes  uint32 file index, int64 bytes of the file written so far
+//	End    uint32 file index, uint8 result (OK, Failed, Skipped)
+//	Done   uint32 files succeeded, uint32 files total
+//
+// Skipped and linked files get an End without a Start, and End with OK means the
+// whole file was written even if the last Bytes frame lagged.
+// Readers should skip frames of unknown type so new types can be added later.
+const (
+	Start byte = 1
+	Bytes byte = 2
+	End   byte = 3
+	Done  byte = 4
+)
+
+// File results carried by End frames
+const (
+	OK      byte = 0
+	Failed  byte = 1
+	Skipped byte = 2
+)
+
+// DefaultInterval is how often a Bytes frame is sent for one file unless New is
+// given another interval
+const DefaultInterval = 100 * time.Millisecond
+
+// closeTimeout bounds how long Close waits for a stalled reader to take the last frames
+const closeTimeout = 2 * time.Second
+
+// Stream writes progress frames from a goroutine of its own, so a reader that falls
+// behind never holds up the downloads. Start, End and Done frames are all written, in
+// order; of a file's Bytes frames only the newest one waiting is kept, so the backlog
+// is bounded by the number of files. All methods are no-ops on a nil Stream and safe for
+// concurrent use. The first write error is passed to onError once and further
+// frames are dropped so a closed pipe does not stop the downloads.
+type Stream struct {
+	mu       sync.Mutex
+	wake     *sync.Cond        // signalled when frames are queued or the stream closes
+	control  [][]byte          // Start, End and Done frames not yet written
+	counts   map[uint32][]byte // the newest Bytes frame of each file not yet written
+	closed   bool
+	done     chan struct{}
+	interval time.Duration
+	dropped  atomic.Int64
+}
+
+// New returns a Stream writing to w that sends a file's Bytes frames at most every
+// interval (0 = DefaultInterval); onError may be nil. Close flushes it.
+func New(w io.Writer, interval time.Duration, onError func(error)) *Stream {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	p := &Stream{counts: map[uint32][]byte{}, done: make(chan struct{}), interval: interval}
+	p.wake = sync.NewCond(&p.mu)
+	go p.write(w, onError)
+	return p
+}
+
+// write sends queued frames to w, control frames first so a file's Bytes frames
+// follow its Start, until the stream is closed and drained
+func (p *Stream) write(w io.Writer, onError func(error)) {
+	defer close(p.done)
+	failed := false
+	p.mu.Lock()
+	for {
+		for len(p.control) == 0 && len(p.counts) == 0 && !p.closed {
+			p.wake.Wait()
+		}
+		if len(p.control) == 0 && len(p.counts) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		batch := p.control
+		indexes := make([]uint32, 0, len(p.counts))
+		for i := range p.counts {
+			indexes = append(indexes, i)
+		}
+		slices.Sort(indexes)
+		for _, i := range indexes {
+			batch = append(batch, p.counts[i])
+		}
+		p.control, p.counts = nil, map[uint32][]byte{}
+		p.mu.Unlock()
+
+		for _, buf := range batch {
+			if failed {
+				break
+			}
+			if _, err := w.Write(buf); err != nil {
+				failed = true
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+		p.mu.Lock()
+	}
+}
+
+// frame encodes fields after the frame type and queues them as one frame for file
+// number index (ignored for Done)
+func (p *Stream) frame(kind byte, index uint32, fields ...any) {
+	var payload bytes.Buffer
+	payload.WriteByte(kind)
+	for _, field := range fields {
+		binary.Write(&payload, binary.BigEndian, field)
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(payload.Len()))
+	buf = append(buf, payload.Bytes()...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	switch pending, waiting := p.counts[index]; {
+	case kind == Bytes:
+		// A newer count replaces the one still waiting
+		if waiting {
+			p.dropped.Add(1)
+		}
+		p.counts[index] = buf
+	case kind == End && waiting:
+		// The file's last count goes out before its End
+		delete(p.counts, index)
+		p.control = append(p.control, pending, buf)
+	default:
+		p.control = append(p.control, buf)
+	}
+	p.wake.Signal()
+}
+
+// Dropped returns how many Bytes frames were superseded before the reader took them
+func (p *Stream) Dropped() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.dropped.Load()
+}
+
+// Close stops the stream after the queued frames are written, waiting at most
+// closeTimeout for a stalled reader. Frames sent after Close are ignored.
+func (p *Stream) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.closed = true
+	p.wake.Signal()
+	p.mu.Unlock()
+	select {
+	case <-p.done:
+	case <-time.After(closeTimeout):
+	}
+}
+
+// Start announces that file number i, of size bytes at path, is about to be downloaded
+func (p *Stream) Start(i int, path string, size int64) {
+	if p == nil {
+		return
+	}
+	p.frame(Start, uint32(i), uint32(i), size, uint16(len(path)), []byte(path))
+}
+
+// End reports how file number i ended
+func (p *Stream) End(i int, result byte) {
+	if p == nil {
+		return
+	}
+	p.frame(End, uint32(i), uint32(i), result)
+}
+
+// Done reports the totals once every file has been handled
+func (p *Stream) Done(succeeded, total int) {
+	if p == nil {
+		return
+	}
+	p.frame(Done, 0, uint32(succeeded), uint32(total))
+}
+
+// Counter returns a writer that sends Bytes frames for file number i as bytes
+// arrive, or nil if there is no stream
+func (p *Stream) Counter(i int) io.Writer {
+	if p == nil {
+		return nil
+	}
+	return &frameCounter{progress: p, index: uint32(i)}
+}
+
+// frameCounter counts one file's bytes and sends them at most every interval
+type frameCounter struct {
+	progress *Stream
+	index    uint32
+	written  int64
+	sent     time.Time
+}
+
+func (c *frameCounter) Write(p []byte) (int, error) {
+	c.written += int64(len(p))
+	if now := time.Now(); now.Sub(c.sent) >= c.progress.interval {
+		c.sent = now
+		c.progress.frame(Bytes, c.index, c.index, c.written)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,161 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// readFrames splits a stream into the payloads of its frames
+func readFrames(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	r := bytes.NewReader(data)
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF {
+			return frames
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatalf("truncated frame: %v", err)
+		}
+		frames = append(frames, payload)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var out bytes.Buffer
+	stream := New(&out, time.Hour, nil)
+	stream.Start(3, "onnx/model.onnx", 1<<40)
+	counter := stream.Counter(3)
+	counter.Write(make([]byte, 100))
+	counter.Write(make([]byte, 50)) // within the interval of the last frame
+	stream.End(3, OK)
+	stream.Done(1, 2)
+	stream.Close()
+	stream.Done(2, 2) // ignored after Close
+
+	frames := readFrames(t, out.Bytes())
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4: %x", len(frames), frames)
+	}
+	start := binary.BigEndian.AppendUint32([]byte{Start}, 3)
+	start = binary.BigEndian.AppendUint64(start, 1<<40)
+	start = binary.BigEndian.AppendUint16(start, uint16(len("onnx/model.onnx")))
+	start = append(start, "onnx/model.onnx"...)
+	want := [][]byte{
+		start,
+		binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint32([]byte{Bytes}, 3), 100),
+		append(binary.BigEndian.AppendUint32([]byte{End}, 3), OK),
+		binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{Done}, 1), 2),
+	}
+	for i := range want {
+		if !bytes.Equal(frames[i], want[i]) {
+			t.Errorf("frame %d = %x, want %x", i, frames[i], want[i])
+		}
+	}
+
+	// A nil stream is silent
+	var none *Stream
+	none.Start(0, "a", 1)
+	none.Close()
+	if none.Counter(0) != nil || none.Dropped() != 0 {
+		t.Error("a nil stream returned a counter")
+	}
+}
+
+// brokenPipe fails every write
+type brokenPipe struct{ writes int }
+
+func (p *brokenPipe) Write(b []byte) (int, error) {
+	p.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestStreamStopsAfterError(t *testing.T) {
+	pipe := &brokenPipe{}
+	var errs []error
+	stream := New(pipe, 0, func(err error) { errs = append(errs, err) })
+	stream.Start(0, "a", 1)
+	stream.End(0, Failed)
+	stream.Done(0, 1)
+	stream.Close()
+	if len(errs) != 1 || pipe.writes != 1 {
+		t.Errorf("%d errors reported after %d writes, want one of each", len(errs), pipe.writes)
+	}
+}
+
+// stalledPipe blocks every write until release is closed
+type stalledPipe struct {
+	release chan struct{}
+	out     bytes.Buffer
+}
+
+func (p *stalledPipe) Write(b []byte) (int, error) {
+	<-p.release
+	return p.out.Write(b)
+}
+
+func TestStreamStalledReader(t *testing.T) {
+	pipe := &stalledPipe{release: make(chan struct{})}
+	stream := New(pipe, 0, nil)
+
+	// Sending never waits for the reader, however far it falls behind
+	const files = 1000
+	start := time.Now()
+	for i := 0; i < files; i++ {
+		stream.Start(i, fmt.Sprintf("shard-%d.bin", i), 1<<20)
+		for j := 1; j <= 10; j++ {
+			stream.frame(Bytes, uint32(i), uint32(i), int64(j*1024))
+		}
+		if i%2 == 0 {
+			stream.End(i, OK)
+		}
+	}
+	stream.Done(files/2, files)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("sending to a stalled reader took %v", elapsed)
+	}
+	if stream.Dropped() == 0 {
+		t.Fatal("no Bytes frames were superseded while the reader stalled")
+	}
+
+	close(pipe.release)
+	stream.Close()
+	var starts, ends, done int
+	ended := map[uint32]bool{}
+	last := map[uint32]int64{}
+	for _, frame := range readFrames(t, pipe.out.Bytes()) {
+		index := binary.BigEndian.Uint32(frame[1:])
+		switch frame[0] {
+		case Start:
+			starts++
+		case End:
+			ends++
+			ended[index] = true
+		case Done:
+			done++
+		case Bytes:
+			if ended[index] {
+				t.Errorf("Bytes frame for file %d after its End", index)
+			}
+			last[index] = int64(binary.BigEndian.Uint64(frame[5:]))
+		}
+	}
+	// Control frames are never dropped, and every file's last count is written
+	if starts != files || ends != files/2 || done != 1 {
+		t.Errorf("got %d Start, %d End and %d Done frames, want %d, %d and 1", starts, ends, done, files, files/2)
+	}
+	for i := uint32(0); i < files; i++ {
+		if last[i] != 10*1024 {
+			t.Errorf("file %d ends at %d bytes, want %d", i, last[i], 10*1024)
+		}
+	}
+}
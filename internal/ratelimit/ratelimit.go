@@ -0,0 +1,150 @@
+// Package ratelimit caps the combined download speed of a run, optionally by time of day.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/internal/units"
+)
+
+// Window applies a rate limit between two times of day (minutes since midnight).
+// A window whose end is before its start wraps past midnight; equal ends cover the whole day.
+type Window struct {
+	Start, End int
+	Rate       int64
+}
+
+// contains reports whether the minute of day falls inside the window
+func (w Window) contains(minute int) bool {
+	if w.Start == w.End {
+		return true
+	}
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// ParseSchedule parses comma-separated "HH:MM-HH:MM=RATE" windows
+func ParseSchedule(spec string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(spec, ",") {
+		span, rate, ok := strings.Cut(strings.TrimSpace(part), "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("window %q is not HH:MM-HH:MM=RATE", part)
+		}
+
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		bytesPerSec, err := units.ParseByteSize(rate)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, Window{Start: start, End: end, Rate: bytesPerSec})
+	}
+	return windows, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Limiter is a token bucket shared by all downloads, so limits apply to
+// aggregate throughput. The active rate comes from the first schedule window
+// containing the current time, falling back to the base rate; 0 means unlimited.
+type Limiter struct {
+	// Schedule overrides the base rate during its windows
+	Schedule []Window
+	// OnChange, if set, is told when a schedule window changes the active rate
+	OnChange func(rate int64)
+
+	mu     sync.Mutex
+	base   int64
+	active int64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(context.Context, time.Duration) error
+}
+
+// New returns a Limiter allowing bytesPerSec outside schedule windows
+func New(bytesPerSec int64) *Limiter {
+	return &Limiter{base: bytesPerSec, active: -1, now: time.Now, sleep: sleepContext}
+}
+
+// sleepContext waits for d, returning early with ctx's error if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateAt returns the limit in effect at t
+func (l *Limiter) rateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range l.Schedule {
+		if w.contains(minute) {
+			return w.Rate
+		}
+	}
+	return l.base
+}
+
+// Wait blocks until n more bytes may be transferred or ctx is done
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	rate := l.rateAt(now)
+	if rate != l.active {
+		if l.active >= 0 && len(l.Schedule) > 0 && l.OnChange != nil {
+			l.OnChange(rate)
+		}
+		l.active = rate
+		l.tokens = 0
+		l.last = now
+	}
+	if rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	// Refill for the time elapsed, allowing at most one second of burst
+	l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		return l.sleep(ctx, delay)
+	}
+	return nil
+}
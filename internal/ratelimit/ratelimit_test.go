@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock drives a Limiter without real sleeping
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) limiter(bytesPerSec int64) *Limiter {
+	l := New(bytesPerSec)
+	l.now = func() time.Time { return c.now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		c.slept = append(c.slept, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+	return l
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := clock.limiter(1000)
+
+	// The bucket starts empty, so every read waits for its share of the rate
+	for i := 0; i < 4; i++ {
+		l.Wait(ctx, 500)
+	}
+	for i, d := range clock.slept {
+		if d != 500*time.Millisecond {
+			t.Errorf("sleep %d = %v, want 500ms", i, d)
+		}
+	}
+	if got := clock.now.Sub(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)); got != 2*time.Second {
+		t.Errorf("2000 bytes at 1000 B/s took %v, want 2s", got)
+	}
+
+	// Idle time refills at most one second of burst
+	clock.slept = nil
+	clock.now = clock.now.Add(time.Minute)
+	l.Wait(ctx, 1000)
+	l.Wait(ctx, 500)
+	if len(clock.slept) != 1 || clock.slept[0] != 500*time.Millisecond {
+		t.Errorf("sleeps after idling = %v, want only 500ms for the read beyond the burst", clock.slept)
+	}
+
+	// 0 means unlimited
+	clock.slept = nil
+	clock.limiter(0).Wait(ctx, 1<<30)
+	if len(clock.slept) != 0 {
+		t.Errorf("an unlimited limiter slept %v", clock.slept)
+	}
+}
+
+func TestLimiterSchedule(t *testing.T) {
+	ctx := context.Background()
+	windows, err := ParseSchedule("09:00-17:00=0,22:00-06:00=2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 10, 0, 0, 0, time.Local)}
+	l := clock.limiter(1000)
+	l.Schedule = windows
+	var changes []int64
+	l.OnChange = func(rate int64) { changes = append(changes, rate) }
+
+	l.Wait(ctx, 5000)
+	if len(clock.slept) != 0 {
+		t.Errorf("slept %v inside an unlimited window", clock.slept)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want time.Duration
+	}{
+		{time.Date(2026, 1, 1, 18, 0, 0, 0, time.Local), 5 * time.Second},         // base rate outside the windows
+		{time.Date(2026, 1, 1, 23, 0, 0, 0, time.Local), 2500 * time.Millisecond}, // window crossing midnight
+		{time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local), 1500 * time.Millisecond},  // same rate, so one second of burst has refilled
+	} {
+		clock.slept = nil
+		clock.now = tt.at
+		l.Wait(ctx, 5000)
+		if len(clock.slept) != 1 || clock.slept[0] != tt.want {
+			t.Errorf("at %s slept %v, want %v", tt.at.Format("15:04"), clock.slept, tt.want)
+		}
+	}
+	if len(changes) != 2 || changes[0] != 1000 || changes[1] != 2000 {
+		t.Errorf("OnChange saw %v, want [1000 2000]", changes)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"09:00=5MB", "09:00-25:00=1MB", "09:00-10:00=fast"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestLimiterStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// A megabyte at 1 KB/s would take over 16 minutes
+	start := time.Now()
+	if err := New(1024).Wait(ctx, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want the context's deadline", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("Wait returned after %v, long after the context ended", waited)
+	}
+}
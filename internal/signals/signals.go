@@ -0,0 +1,21 @@
+// Package signals subscribes to the POSIX signals hugdl reacts to, on platforms that
+// have them. Elsewhere the subscriptions are no-ops, so the channels never fire.
+package signals
+
+import "os"
+
+// NotifyResize relays terminal resize signals (SIGWINCH) to c. Stop delivery with
+// signal.Stop(c).
+func NotifyResize(c chan<- os.Signal) {
+	notifyResize(c)
+}
+
+// NotifyPause relays requests to pause (SIGUSR1) to c
+func NotifyPause(c chan<- os.Signal) {
+	notifyPause(c)
+}
+
+// NotifyResume relays requests to resume after a pause (SIGUSR2) to c
+func NotifyResume(c chan<- os.Signal) {
+	notifyResume(c)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package signals
+
+import "os"
+
+// Windows consoles send no resize signal, and there are no user signals to pause with
+func notifyResize(c chan<- os.Signal) {}
+
+func notifyPause(c chan<- os.Signal) {}
+
+func notifyResume(c chan<- os.Signal) {}
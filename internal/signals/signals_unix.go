@@ -0,0 +1,22 @@
+//go:build unix
+
+package signals
+
+import (
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, unix.SIGWINCH)
+}
+
+func notifyPause(c chan<- os.Signal) {
+	signal.Notify(c, unix.SIGUSR1)
+}
+
+func notifyResume(c chan<- os.Signal) {
+	signal.Notify(c, unix.SIGUSR2)
+}
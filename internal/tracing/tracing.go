@@ -0,0 +1,124 @@
+// Package tracing records the timings of HTTP requests for -http-trace-file.
+package tracing
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// traceRecord is one request written by a Transport. Durations are in milliseconds
+// from the start of the request; redirects are traced as separate requests.
+type traceRecord struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Reused    bool      `json:"reused"`
+	DNS       float64   `json:"dns_ms,omitempty"`
+	Connect   float64   `json:"connect_ms,omitempty"`
+	TLS       float64   `json:"tls_ms,omitempty"`
+	FirstByte float64   `json:"first_byte_ms,omitempty"`
+	Total     float64   `json:"total_ms"`
+	TLSVer    string    `json:"tls_version,omitempty"`
+	Cipher    string    `json:"tls_cipher,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Transport records an httptrace-derived traceRecord for every request sent
+// through Base, writing each as a JSON line to Out. Records are written when the
+// response body is closed so they include the transfer.
+type Transport struct {
+	Base http.RoundTripper
+	Out  io.Writer
+	mu   sync.Mutex
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	since := func() float64 { return float64(time.Since(start).Microseconds()) / 1000 }
+	rec := &traceRecord{Time: start, Method: req.Method, URL: req.URL.String()}
+
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn:  func(info httptrace.GotConnInfo) { rec.Reused = info.Reused },
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rec.DNS = float64(time.Since(dnsStart).Microseconds()) / 1000
+		},
+		ConnectStart: func(string, string) { connectStart = time.Now() },
+		ConnectDone: func(string, string, error) {
+			rec.Connect = float64(time.Since(connectStart).Microseconds()) / 1000
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			rec.TLS = float64(time.Since(tlsStart).Microseconds()) / 1000
+			rec.TLSVer = tls.VersionName(state.Version)
+			rec.Cipher = tls.CipherSuiteName(state.CipherSuite)
+		},
+		GotFirstResponseByte: func() { rec.FirstByte = since() },
+	}
+
+	resp, err := t.Base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		rec.Error = err.Error()
+		rec.Total = since()
+		t.write(rec)
+		return nil, err
+	}
+	rec.Status = resp.StatusCode
+	rec.Location = resp.Header.Get("Location")
+	resp.Body = &tracedBody{ReadCloser: resp.Body, done: func(n int64, err error) {
+		rec.Bytes = n
+		if err != nil && err != io.EOF {
+			rec.Error = err.Error()
+		}
+		rec.Total = since()
+		t.write(rec)
+	}}
+	return resp, nil
+}
+
+// write appends rec to the trace file as one JSON line
+func (t *Transport) write(rec *traceRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Out.Write(append(data, '\n'))
+}
+
+// tracedBody counts the bytes read from a response and reports them once on Close
+type tracedBody struct {
+	io.ReadCloser
+	n      int64
+	err    error
+	done   func(n int64, err error)
+	closed bool
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.closed {
+		b.closed = true
+		b.done(b.n, b.err)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Out: &out}}
+	resp, err := client.Get(server.URL + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp.Body.Close()
+	client.Get("http://127.0.0.1:0/unreachable")
+
+	// One line per request: the redirect, the transfer and the failed connection
+	var records []traceRecord
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var rec traceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("traced %d requests, want 3: %+v", len(records), records)
+	}
+	if rec := records[0]; rec.Status != http.StatusFound || rec.Location != "/new" || rec.Method != "GET" {
+		t.Errorf("redirect record = %+v", rec)
+	}
+	if rec := records[1]; rec.Status != http.StatusOK || rec.Bytes != 10 || rec.URL != server.URL+"/new" || !rec.Reused || rec.Error != "" {
+		t.Errorf("transfer record = %+v, want 10 bytes over the reused connection", rec)
+	}
+	if rec := records[2]; rec.Error == "" || rec.Status != 0 {
+		t.Errorf("failed request record = %+v, want an error", rec)
+	}
+}
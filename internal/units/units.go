@@ -0,0 +1,56 @@
+// Package units parses and formats the byte sizes and rates used by hugdl's options and output.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseByteSize parses sizes like "500KB", "5MB", "1.5GB" or a plain byte count
+func ParseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+
+	factor := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			factor = unit.factor
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * factor), nil
+}
+
+// FormatSize renders a byte count in B, KB, MB or GB
+func FormatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.2f KB", float64(bytes)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", bytes)
+	}
+}
+
+// FormatRate renders a bytes-per-second limit for display
+func FormatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.1f KB/s", float64(rate)/1024)
+}
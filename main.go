@@ -1,3 +1,8 @@
+//go:build ignore
+
+// main.go is built on its own (go run main.go), so it stays out of the package
+// formed by hugdl.go and its tests.
+
 package main
 
 import (
@@ -115,7 +120,7 @@ func main() {
 
 // getModelFiles fetches the list of files from HuggingFace API
 func getModelFiles(config DownloadConfig) ([]ModelInfo, error) {
-	apiURL := fmt.Sprintf("%s/models/%s/tree/main", config.APIURL, config.ModelName)
+	apiURL := fmt.Sprintf("%s/models/%s/tree/main?recursive=true", config.APIURL, config.ModelName)
 	
 	resp, err := http.Get(apiURL)
 	if err != nil {
@@ -157,8 +162,12 @@ func downloadFile(config DownloadConfig, file ModelInfo) error {
 	// Create download URL
 	downloadURL := fmt.Sprintf("%s/%s/resolve/main/%s", config.BaseURL, config.ModelName, file.Path)
 	
-	// Create output file path
-	outputPath := filepath.Join(config.ModelDir, file.Name)
+	// Create output file path, keeping the repo's directory structure
+	localPath := filepath.FromSlash(file.Path)
+	if !filepath.IsLocal(localPath) {
+		return fmt.Errorf("refusing unsafe repo path %q", file.Path)
+	}
+	outputPath := filepath.Join(config.ModelDir, localPath)
 	
 	// Create HTTP request
 	req, err := http.NewRequest("GET", downloadURL, nil)
@@ -182,7 +191,10 @@ func downloadFile(config DownloadConfig, file ModelInfo) error {
 		return fmt.Errorf("download failed with status: %d", resp.StatusCode)
 	}
 
-	// Create output file
+	// Create output file and any intermediate directories
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
 	out, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
@@ -0,0 +1,866 @@
+package hugdl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"downloader/internal/diskspace"
+)
+
+// PartSuffix marks a download in progress; an interrupted download leaves it behind for resuming
+const PartSuffix = ".part"
+
+// DownloadOptions configures Client.Download
+type DownloadOptions struct {
+	// Revision is the branch, tag or commit to download; empty means DefaultRevision
+	Revision string
+	// OutputDir receives the files under their repo paths
+	OutputDir string
+	// Filter selects the files to download; nil downloads every file
+	Filter func(File) bool
+	// Concurrency is the number of files downloaded in parallel; 0 means
+	// DefaultConcurrency for this machine
+	Concurrency int
+	// Retries is how many times each file's requests are retried on network errors, 429 and 5xx
+	Retries int
+	// Progress, if set, is called from the downloading goroutine as bytes of a file arrive
+	Progress func(file File, written int64)
+}
+
+// Download fetches the selected files of model into opts.OutputDir and returns the
+// files that are now in place. Files whose local copy already has the expected size
+// are kept without downloading them again. Failures of individual files are joined
+// into the returned error; the other files are still downloaded unless ctx is cancelled.
+// DownloadAll reports on every file in more detail.
+func (c *Client) Download(ctx context.Context, model string, opts DownloadOptions) ([]File, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("no output directory given")
+	}
+	report, err := c.DownloadAll(ctx, DownloadAllOptions{
+		Model:       model,
+		Revision:    opts.Revision,
+		Dest:        opts.OutputDir,
+		Filter:      opts.Filter,
+		Concurrency: opts.Concurrency,
+		Retries:     opts.Retries,
+		Progress:    opts.Progress,
+	})
+	if report == nil {
+		return nil, err
+	}
+	var written []File
+	for _, result := range report.Files {
+		if result.Status == StatusDownloaded || result.Status == StatusSkipped {
+			written = append(written, result.File)
+		}
+	}
+	return written, err
+}
+
+// FileOptions tunes how Client.DownloadFile writes a file
+type FileOptions struct {
+	// Retries is how many times a download is retried on network errors, 429 and 5xx,
+	// including connections dropped while the body is read
+	Retries int
+	// ChecksumRetries is how many times content that fails verification is downloaded again
+	ChecksumRetries int
+	// PreAllocate reserves space for the whole file before writing
+	PreAllocate bool
+	// KeepCorrupt moves content that fails verification into place instead of deleting it
+	KeepCorrupt bool
+	// NoVerify skips comparing the content with the repo oid and the server's ETag
+	NoVerify bool
+	// NoResume starts over instead of resuming the part files of an interrupted download
+	NoResume bool
+	// CheckRemoteSize sends a HEAD request before resuming a part file and restarts
+	// the download if the remote size no longer matches
+	CheckRemoteSize bool
+	// VerifyResume checks a part file before resuming it: the last block it holds
+	// must match the same bytes fetched from the server, or the download restarts
+	VerifyResume bool
+	// FileMode is set on each file before it is moved into place; 0 keeps the
+	// mode the part file was created with (0666 minus the umask)
+	FileMode os.FileMode
+	// PartPaths, if set, are where the partial download of each output path is kept
+	// instead of next to it, e.g. in a cache directory; see Layout.PartPath
+	PartPaths []string
+	// DiskFullWait is how long to wait for space when the disk fills up (0 = fail immediately)
+	DiskFullWait time.Duration
+	// Limiter throttles reading the body; nil means unlimited
+	Limiter Limiter
+	// Writers receive every byte of the file, starting with a resumed prefix
+	Writers []io.Writer
+	// OpenSink, if set, is called once the response is accepted. The sink receives
+	// the whole file like Writers, and its Close error fails the download.
+	OpenSink func(ctx context.Context) (Sink, error)
+	// OnEvent, if set, is called from the downloading goroutine as the download progresses
+	OnEvent func(Event)
+}
+
+// Limiter caps throughput, e.g. across all files of a run
+type Limiter interface {
+	// Wait blocks until n more bytes may be transferred, returning ctx's error if it
+	// is done first
+	Wait(ctx context.Context, n int) error
+}
+
+// Sink consumes a file's content as it is downloaded, e.g. a process reading it on stdin
+type Sink interface {
+	io.Writer
+	// Close is called once the body is complete; an error fails the download
+	Close() error
+	// Abort is called instead of Close when the download fails
+	Abort()
+}
+
+// EventKind tells what an Event reports
+type EventKind int
+
+const (
+	// EventStart: the body starts arriving; Offset bytes were resumed from the .part files
+	EventStart EventKind = iota
+	// EventRetry: a request failed with Err, classified as Reason, and is retried after
+	// Delay as attempt Attempt of Max
+	EventRetry
+	// EventChecksumRetry: the content failed verification with Err and is downloaded
+	// again from the start as attempt Attempt of Max
+	EventChecksumRetry
+	// EventSizeUnknown: the remote size could not be checked before resuming (Status); resuming anyway
+	EventSizeUnknown
+	// EventSizeChanged: the remote size is now Size, so the download restarts
+	EventSizeChanged
+	// EventRangeIgnored: the server sent the whole file instead of the range, so the download restarts
+	EventRangeIgnored
+	// EventResumeRejected: a part file failed the FileOptions.VerifyResume check (Err), so
+	// the download restarts
+	EventResumeRejected
+	// EventPreAllocateFailed: space for Path could not be reserved (Err); it is written anyway
+	EventPreAllocateFailed
+	// EventDiskFull: the disk is full; writing waits up to Delay for free space
+	EventDiskFull
+	// EventVerified: Path matched the repo hash and was moved into place
+	EventVerified
+	// EventDone: the download finished after writing Size bytes
+	EventDone
+)
+
+// Event reports the progress of one Client.DownloadFile call
+type Event struct {
+	Kind    EventKind
+	File    File
+	Path    string
+	Offset  int64
+	Size    int64
+	Attempt int
+	Max     int
+	Status  int
+	Delay   time.Duration
+	Reason  RetryReason
+	Err     error
+}
+
+// notifier returns the function sending opts.OnEvent the events of file
+func (opts FileOptions) notifier(file File) func(Event) {
+	return func(e Event) {
+		if opts.OnEvent != nil {
+			e.File = file
+			opts.OnEvent(e)
+		}
+	}
+}
+
+// Result is what the resolve endpoint reported about a downloaded file
+type Result struct {
+	// Commit is the commit the file was served from
+	Commit string
+	// ETag is the server's hash of the content: the SHA256 for LFS files, the git oid otherwise
+	ETag string
+}
+
+// MirrorError reports the output paths a download could not be written to
+// while at least one other path received it.
+type MirrorError struct {
+	Paths map[string]error
+}
+
+func (e *MirrorError) Error() string {
+	var msgs []string
+	for path, err := range e.Paths {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", path, err))
+	}
+	sort.Strings(msgs)
+	return "failed to write mirrors: " + strings.Join(msgs, "; ")
+}
+
+// Failed returns the error for outputPath, or nil if it was written (also for a nil receiver)
+func (e *MirrorError) Failed(outputPath string) error {
+	if e == nil {
+		return nil
+	}
+	return e.Paths[outputPath]
+}
+
+// DownloadFile downloads file of model at revision to every path in outputPaths.
+// Content is written to <path>.part, or opts.PartPaths, and moved into place once complete; a .part left by an
+// interrupted download is resumed with a Range request when the server supports it.
+// The content is checked against the file's oid and the X-Linked-Etag/ETag the server
+// sends. If only some paths fail, the error is a *MirrorError naming them; content
+// that fails verification is a *ChecksumError once opts.ChecksumRetries fresh
+// downloads have failed too.
+func (c *Client) DownloadFile(ctx context.Context, model, revision string, file File, outputPaths []string, opts FileOptions) (Result, error) {
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	notify := opts.notifier(file)
+	for attempt := 0; ; attempt++ {
+		// Only the last attempt may keep corrupt content
+		attemptOpts := opts
+		last := attempt >= opts.ChecksumRetries
+		attemptOpts.KeepCorrupt = opts.KeepCorrupt && last
+
+		result, err := c.downloadAttempt(ctx, model, revision, file, outputPaths, attemptOpts, notify)
+		var sumErr *ChecksumError
+		if last || !errors.As(err, &sumErr) || ctx.Err() != nil {
+			return result, err
+		}
+		// The .part was discarded, so the next attempt cannot resume corrupt bytes
+		notify(Event{Kind: EventChecksumRetry, Attempt: attempt + 2, Max: opts.ChecksumRetries + 1, Err: err})
+	}
+}
+
+// downloadAttempt makes one download of file as described for DownloadFile
+func (c *Client) downloadAttempt(ctx context.Context, model, revision string, file File, outputPaths []string, opts FileOptions, notify func(Event)) (Result, error) {
+	downloadURL := c.resolveURL(model, revision, file.Path)
+
+	// Pick up where an interrupted download stopped
+	partPaths := opts.partFiles(outputPaths)
+	offset := partOffset(partPaths, file.Size)
+	if opts.NoResume {
+		offset = 0
+	}
+
+	// Make a request bound to ctx, keeping the Hub's headers when LFS files redirect to the CDN
+	var hubHeader http.Header
+	method := "GET"
+	var tail int64 // set to fetch only the block before offset
+	newRequest := func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, method, downloadURL)
+		if err != nil {
+			return nil, err
+		}
+
+		// Add headers to mimic browser
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+		req.Header.Set("Accept", "*/*")
+		switch {
+		case tail > 0:
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset-tail, offset-1))
+		case offset > 0 && method == "GET":
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		hubHeader = nil
+		return req, nil
+	}
+	client := &http.Client{
+		Transport: c.HTTPClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if hubHeader == nil && req.Response != nil {
+				hubHeader = req.Response.Header
+			}
+			return c.CheckRedirect(req, via)
+		},
+	}
+
+	// Every request for the file draws on the same retries
+	budget := &retryBudget{max: opts.Retries, notify: notify}
+
+	// Appending to a part written for a different upstream size would only fail verification
+	if offset > 0 && opts.CheckRemoteSize {
+		method = "HEAD"
+		resp, err := doWithRetries(ctx, client, newRequest, budget)
+		method = "GET"
+		if err != nil {
+			return Result{}, err
+		}
+		resp.Body.Close()
+		size := resp.ContentLength
+		if hubHeader != nil && hubHeader.Get("X-Linked-Size") != "" {
+			size, _ = strconv.ParseInt(hubHeader.Get("X-Linked-Size"), 10, 64)
+		}
+		switch {
+		case resp.StatusCode != http.StatusOK || size < 0:
+			notify(Event{Kind: EventSizeUnknown, Status: resp.StatusCode})
+		case size != file.Size:
+			notify(Event{Kind: EventSizeChanged, Size: size})
+			offset = 0
+		}
+	}
+
+	// A part damaged on disk would only be completed into a file that fails verification
+	if offset > 0 && opts.VerifyResume {
+		tail = min(offset, resumeCheckBlock)
+		resp, err := doWithRetries(ctx, client, newRequest, budget)
+		tail = 0
+		if err != nil {
+			return Result{}, err
+		}
+		err = checkPartTail(partPaths, offset, resp)
+		resp.Body.Close()
+		if err != nil {
+			notify(Event{Kind: EventResumeRejected, Err: err})
+			offset = 0
+		}
+	}
+
+	resp, err := doWithRetries(ctx, client, newRequest, budget)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			notify(Event{Kind: EventRangeIgnored})
+			offset = 0
+		}
+	case http.StatusPartialContent:
+		if want := fmt.Sprintf("bytes %d-", offset); offset == 0 || !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+			return Result{}, fmt.Errorf("unexpected partial response (Content-Range %q)", resp.Header.Get("Content-Range"))
+		}
+	default:
+		return Result{}, newStatusError(resp)
+	}
+
+	// LFS files carry their SHA256 in X-Linked-Etag, regular files their git oid in ETag
+	if hubHeader == nil {
+		hubHeader = resp.Header
+	}
+	result := Result{Commit: hubHeader.Get("X-Repo-Commit")}
+	if linked := hubHeader.Get("X-Linked-Etag"); linked != "" {
+		result.ETag = parseETag(linked)
+	} else {
+		result.ETag = parseETag(hubHeader.Get("ETag"))
+	}
+	expected := file.ExpectedOid()
+	if opts.NoVerify {
+		expected = ""
+	}
+	if expected != "" && result.ETag != "" && result.ETag != expected {
+		return result, fmt.Errorf("remote file changed since listing (oid %s, server sent %s)", expected, result.ETag)
+	}
+
+	// Create or reopen the partial files, one per mirror
+	out := &mirrorWriter{
+		files:   make([]*os.File, len(outputPaths)),
+		writers: make([]io.Writer, len(outputPaths)),
+		errs:    make([]error, len(outputPaths)),
+	}
+	created := 0
+	for i, partPath := range partPaths {
+		f, err := openPartFile(partPath, offset)
+		if err != nil {
+			out.errs[i] = err
+			continue
+		}
+		defer f.Close()
+		if opts.PreAllocate && file.Size > 0 {
+			if err := diskspace.Allocate(f, file.Size); err != nil {
+				if isDiskFull(err) {
+					out.errs[i] = fmt.Errorf("not enough space for %d bytes: %w", file.Size, err)
+					f.Close()
+					os.Remove(partPath)
+					continue
+				}
+				notify(Event{Kind: EventPreAllocateFailed, Path: outputPaths[i], Err: err})
+			}
+		}
+		out.files[i] = f
+		out.writers[i] = f
+		if opts.DiskFullWait > 0 {
+			out.writers[i] = &diskFullWriter{ctx: ctx, w: f, timeout: opts.DiskFullWait, poll: 5 * time.Second, notify: notify}
+		}
+		created++
+	}
+	if created == 0 {
+		return result, errors.Join(out.errs...)
+	}
+
+	notify(Event{Kind: EventStart, Offset: offset})
+
+	// The hash and the writers start with the bytes already on disk
+	hasher := NewOidHash(file.LFS, file.Size)
+	var writers []io.Writer
+	if expected != "" {
+		writers = append(writers, hasher)
+	}
+	writers = append(writers, opts.Writers...)
+	// Keep the start of small bodies to recognize an LFS pointer served in place of the content
+	var head *headWriter
+	if offset < lfsPointerMaxSize && file.Size > lfsPointerMaxSize {
+		head = &headWriter{max: lfsPointerMaxSize}
+		writers = append(writers, head)
+	}
+	// The sink sees the whole file, including a resumed prefix
+	var sink Sink
+	sinkClosed := false
+	if opts.OpenSink != nil {
+		sink, err = opts.OpenSink(ctx)
+		if err != nil {
+			return result, err
+		}
+		defer func() {
+			if !sinkClosed {
+				sink.Abort()
+			}
+		}()
+		writers = append(writers, sink)
+	}
+	if offset > 0 && len(writers) > 0 {
+		if err := readPrefix(partPaths, out.files, offset, io.MultiWriter(writers...)); err != nil {
+			return result, err
+		}
+	}
+
+	// Copy, hashing as we go. A connection that drops part-way is picked
+	// up with a range request from the bytes written so far.
+	dst := io.MultiWriter(append([]io.Writer{out}, writers...)...)
+	written := offset
+	for {
+		body := &errorReader{r: resp.Body}
+		var src io.Reader = body
+		if opts.Limiter != nil {
+			src = &limitedReader{ctx: ctx, r: body, limiter: opts.Limiter}
+		}
+		copied, err := io.Copy(dst, src)
+		written += copied
+		if err == nil {
+			break
+		}
+		if body.err == nil || ctx.Err() != nil || budget.spent() {
+			return result, fmt.Errorf("failed to save file (partial download kept for resuming): %w", err)
+		}
+		if err := budget.wait(ctx, retryReason(body.err, 0), body.err, 0); err != nil {
+			return result, err
+		}
+
+		resp.Body.Close()
+		offset = written
+		next, err := doWithRetries(ctx, client, newRequest, budget)
+		if err != nil {
+			return result, err
+		}
+		resp = next
+		if want := fmt.Sprintf("bytes %d-", offset); resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+			return result, fmt.Errorf("connection lost after %d bytes and the server did not continue from there (status %d; partial download kept for resuming)", offset, resp.StatusCode)
+		}
+	}
+
+	// A pointer is not a prefix of the content, so its parts are dropped rather than resumed
+	if head != nil && written < file.Size && isLFSPointer(head.buf) {
+		for i, partPath := range partPaths {
+			out.discard(i, partPath)
+		}
+		return result, fmt.Errorf("server sent the Git LFS pointer (%d bytes) instead of the %d-byte file; "+
+			"the repo may need an access token, or retry later", written, file.Size)
+	}
+
+	// A failing sink fails the file; its parts are dropped so the next download feeds it again
+	if sink != nil {
+		sinkClosed = true
+		if err := sink.Close(); err != nil {
+			for i, partPath := range partPaths {
+				out.discard(i, partPath)
+			}
+			return result, err
+		}
+	}
+
+	if expected != "" {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+			for i, outputPath := range outputPaths {
+				if opts.KeepCorrupt && out.files[i] != nil {
+					out.files[i].Close()
+					moveIntoPlace(partPaths[i], outputPath)
+					continue
+				}
+				out.discard(i, partPaths[i])
+			}
+			return result, &ChecksumError{Expected: expected, Actual: actual}
+		}
+	}
+
+	// Trim pre-allocated space the content did not fill
+	if opts.PreAllocate {
+		for i, f := range out.files {
+			if f != nil && out.errs[i] == nil {
+				if err := f.Truncate(written); err != nil {
+					out.errs[i] = err
+				}
+			}
+		}
+	}
+
+	// Drop mirrors that failed part-way so no truncated copies are left behind,
+	// and move the complete ones into place
+	failed := map[string]error{}
+	for i, outputPath := range outputPaths {
+		if out.errs[i] == nil && out.files[i] != nil {
+			if err := out.files[i].Close(); err != nil {
+				out.errs[i] = err
+			} else if err := setFileMode(partPaths[i], opts.FileMode); err != nil {
+				out.errs[i] = err
+			} else if err := moveIntoPlace(partPaths[i], outputPath); err != nil {
+				out.errs[i] = fmt.Errorf("failed to move download into place: %w", err)
+			}
+		}
+		if out.errs[i] != nil {
+			out.discard(i, partPaths[i])
+			failed[outputPath] = out.errs[i]
+		} else if expected != "" {
+			notify(Event{Kind: EventVerified, Path: outputPath})
+		}
+	}
+
+	notify(Event{Kind: EventDone, Size: written})
+
+	if len(failed) > 0 {
+		return result, &MirrorError{Paths: failed}
+	}
+	return result, nil
+}
+
+// partFiles returns the partial file of each output path: opts.PartPaths if set,
+// <path>.part otherwise
+func (opts FileOptions) partFiles(outputPaths []string) []string {
+	if len(opts.PartPaths) == len(outputPaths) {
+		return opts.PartPaths
+	}
+	parts := make([]string, len(outputPaths))
+	for i, outputPath := range outputPaths {
+		parts[i] = outputPath + PartSuffix
+	}
+	return parts
+}
+
+// ResumeOffset returns how many bytes the partial file of every output path already
+// holds, i.e. where DownloadFile would resume a file of the given size. Any missing
+// part, or a part at least as long as the file, means starting over.
+func ResumeOffset(outputPaths []string, size int64) int64 {
+	return partOffset(FileOptions{}.partFiles(outputPaths), size)
+}
+
+// partOffset is ResumeOffset for the part files themselves
+func partOffset(partPaths []string, size int64) int64 {
+	offset := int64(-1)
+	for _, partPath := range partPaths {
+		stat, err := os.Stat(partPath)
+		if err != nil || !stat.Mode().IsRegular() {
+			return 0
+		}
+		if offset < 0 || stat.Size() < offset {
+			offset = stat.Size()
+		}
+	}
+	if offset < 0 || (size > 0 && offset >= size) {
+		return 0
+	}
+	return offset
+}
+
+// openPartFile opens partPath for writing at offset, creating it when starting from zero
+func openPartFile(partPath string, offset int64) (*os.File, error) {
+	if offset == 0 {
+		if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+		f, err := os.Create(partPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		return f, nil
+	}
+	f, err := os.OpenFile(partPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen partial file: %w", err)
+	}
+	// Mirrors may hold more than the common offset; drop the excess
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// moveIntoPlace renames a finished part file to outputPath. A part kept on another
+// filesystem, e.g. in a cache directory, is copied next to outputPath first so the
+// file never appears half-written under its real name.
+func moveIntoPlace(partPath, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(partPath, outputPath)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	tmp := outputPath + PartSuffix
+	if err := copyFile(partPath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, outputPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(partPath)
+}
+
+// copyFile copies src to dst with src's permissions
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// The umask may have narrowed the mode OpenFile created dst with
+	return os.Chmod(dst, stat.Mode().Perm())
+}
+
+// isCrossDevice reports whether err is a rename between filesystems
+func isCrossDevice(err error) bool {
+	if errors.Is(err, syscall.EXDEV) {
+		return true
+	}
+	// ERROR_NOT_SAME_DEVICE
+	var errno syscall.Errno
+	return runtime.GOOS == "windows" && errors.As(err, &errno) && errno == 17
+}
+
+// resumeCheckBlock is how much of the end of a part file FileOptions.VerifyResume compares
+const resumeCheckBlock = 64 << 10
+
+// checkPartTail compares the block of every part file that ends at offset with the
+// same range of the file in resp. A server that ignored the range cannot be compared
+// with, but the download then restarts anyway.
+func checkPartTail(partPaths []string, offset int64, resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+	tail := min(offset, resumeCheckBlock)
+	if want := fmt.Sprintf("bytes %d-%d/", offset-tail, offset-1); !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+		return fmt.Errorf("unexpected partial response (Content-Range %q)", resp.Header.Get("Content-Range"))
+	}
+	want := make([]byte, tail)
+	if _, err := io.ReadFull(resp.Body, want); err != nil {
+		return fmt.Errorf("could not fetch the last %d bytes to compare: %w", tail, err)
+	}
+	got := make([]byte, tail)
+	for _, partPath := range partPaths {
+		f, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		_, err = f.ReadAt(got, offset-tail)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s is shorter than %d bytes: %w", partPath, offset, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("the last %d bytes of %s differ from the server's", tail, partPath)
+		}
+	}
+	return nil
+}
+
+// readPrefix feeds the first offset bytes of the first usable partial file to w
+func readPrefix(partPaths []string, files []*os.File, offset int64, w io.Writer) error {
+	for i, partPath := range partPaths {
+		if files[i] == nil {
+			continue
+		}
+		f, err := os.Open(partPath)
+		if err != nil {
+			return fmt.Errorf("failed to read partial file: %w", err)
+		}
+		defer f.Close()
+		if _, err := io.CopyN(w, f, offset); err != nil {
+			return fmt.Errorf("failed to read partial file: %w", err)
+		}
+		return nil
+	}
+	return errors.New("no partial file to resume from")
+}
+
+// setFileMode gives a finished part file its final permissions before the rename, so
+// the file never appears under its real name with the wrong mode. Chmod is not masked
+// by the umask, so the mode is exact; on Windows only the owner write bit has an effect.
+func setFileMode(partPath string, mode os.FileMode) error {
+	if mode == 0 {
+		return nil
+	}
+	if err := os.Chmod(partPath, mode); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return nil
+}
+
+// parseETag strips the quotes and weak prefix from an ETag header value
+func parseETag(value string) string {
+	return strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+}
+
+// mirrorWriter writes to several destinations, dropping any that fail so the others continue
+type mirrorWriter struct {
+	files   []*os.File
+	writers []io.Writer
+	errs    []error
+}
+
+// discard closes and removes the copy written to outputPath at index i
+func (w *mirrorWriter) discard(i int, outputPath string) {
+	if w.files[i] != nil {
+		w.files[i].Close()
+		os.Remove(outputPath)
+	}
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	var lastErr error
+	alive := 0
+	for i, dst := range w.writers {
+		if w.errs[i] != nil {
+			continue
+		}
+		if _, err := dst.Write(p); err != nil {
+			w.errs[i] = err
+			lastErr = err
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		return 0, lastErr
+	}
+	return len(p), nil
+}
+
+// limitedReader throttles reads through a Limiter, stopping once ctx is done
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.Wait(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// lfsPointerMaxSize is the largest Git LFS pointer file allowed by the spec
+const lfsPointerMaxSize = 1024
+
+// lfsPointerPrefix starts every Git LFS pointer file
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// isLFSPointer reports whether content is a Git LFS pointer rather than file content
+func isLFSPointer(content []byte) bool {
+	return len(content) <= lfsPointerMaxSize && bytes.HasPrefix(content, []byte(lfsPointerPrefix))
+}
+
+// headWriter keeps the first max+1 bytes written to it and discards the rest, so
+// content longer than max can be told apart from content of exactly max bytes
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if room := h.max + 1 - len(h.buf); room > 0 {
+		h.buf = append(h.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// diskFullWriter retries writes that hit a full disk until space frees up, the wait
+// times out or ctx is done
+type diskFullWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	timeout time.Duration
+	poll    time.Duration
+	notify  func(Event)
+}
+
+func (d *diskFullWriter) Write(p []byte) (int, error) {
+	written := 0
+	var deadline time.Time
+	for {
+		n, err := d.w.Write(p[written:])
+		written += n
+		if err == nil || !isDiskFull(err) {
+			return written, err
+		}
+
+		if deadline.IsZero() {
+			deadline = time.Now().Add(d.timeout)
+			d.notify(Event{Kind: EventDiskFull, Delay: d.timeout, Err: err})
+		}
+		if time.Now().After(deadline) {
+			return written, fmt.Errorf("disk still full after %s: %w", d.timeout, err)
+		}
+		timer := time.NewTimer(d.poll)
+		select {
+		case <-d.ctx.Done():
+			timer.Stop()
+			return written, d.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isDiskFull reports whether err means the target volume ran out of space
+func isDiskFull(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+	var errno syscall.Errno
+	return runtime.GOOS == "windows" && errors.As(err, &errno) && (errno == 39 || errno == 112)
+}
@@ -0,0 +1,85 @@
+package hugdl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"downloader/pkg/hugdl"
+)
+
+// Download the small files of a model, skipping the LFS-tracked weights
+func ExampleClient_Download() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := hugdl.NewClient()
+	files, err := client.Download(ctx, "Qwen/Qwen2.5-Coder-0.5B", hugdl.DownloadOptions{
+		OutputDir: "models/qwen",
+		Filter:    func(f hugdl.File) bool { return !f.LFS },
+		Retries:   3,
+	})
+	var sumErr *hugdl.ChecksumError
+	if errors.As(err, &sumErr) {
+		log.Printf("corrupt download: %v", sumErr)
+	}
+	for _, file := range files {
+		fmt.Println(file.Path)
+	}
+}
+
+// Download a model and report how each file ended
+func ExampleDownloadAll() {
+	report, err := hugdl.DownloadAll(context.Background(), hugdl.DownloadAllOptions{
+		Model:       "Qwen/Qwen2.5-Coder-0.5B",
+		Dest:        "models/qwen",
+		Concurrency: 8,
+		Retries:     3,
+	})
+	if report == nil {
+		log.Fatal(err)
+	}
+	for _, result := range report.Files {
+		fmt.Printf("%s: %s, %d bytes in %s\n", result.File.Path, result.Status, result.Bytes, result.Duration)
+	}
+	if err != nil {
+		log.Printf("%d files failed: %v", report.Count(hugdl.StatusFailed), err)
+	}
+}
+
+// List a revision and download one file to two places, resuming an earlier
+// attempt and reporting retries as they happen
+func ExampleClient_DownloadFile() {
+	ctx := context.Background()
+	client := hugdl.NewClient()
+
+	files, err := client.ListFiles(ctx, "Qwen/Qwen2.5-Coder-0.5B-GGUF", "main")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Path, "q4_k_m.gguf") {
+			continue
+		}
+		outputPaths := []string{"models/" + file.Name(), "backup/" + file.Name()}
+		if offset := hugdl.ResumeOffset(outputPaths, file.Size); offset > 0 {
+			fmt.Printf("resuming %s at %d bytes\n", file.Path, offset)
+		}
+		result, err := client.DownloadFile(ctx, "Qwen/Qwen2.5-Coder-0.5B-GGUF", "main", file, outputPaths, hugdl.FileOptions{
+			Retries: 5,
+			OnEvent: func(e hugdl.Event) {
+				if e.Kind == hugdl.EventRetry {
+					log.Printf("retrying %s in %s: %v", e.File.Path, e.Delay, e.Err)
+				}
+			},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s from commit %s\n", file.Path, result.Commit)
+	}
+}
@@ -0,0 +1,410 @@
+package hugdl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// resolveURL returns the download URL of repoPath in model at revision
+func (c *Client) resolveURL(model, revision, repoPath string) string {
+	return fmt.Sprintf("%s/%s/resolve/%s/%s", c.Endpoint, model, neturl.PathEscape(revision), repoPath)
+}
+
+// get sends an authorized GET request bound to ctx
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// getJSON fetches url and decodes its JSON body into v
+func (c *Client) getJSON(ctx context.Context, url, what string, v any) error {
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", what, err)
+	}
+	return nil
+}
+
+// ModelDetails holds the metadata the Hub reports for a model
+type ModelDetails struct {
+	ID          string   `json:"id"`
+	Sha         string   `json:"sha,omitempty"`
+	PipelineTag string   `json:"pipeline_tag,omitempty"`
+	Library     string   `json:"library_name,omitempty"`
+	License     string   `json:"license,omitempty"`
+	Downloads   int64    `json:"downloads"`
+	Likes       int64    `json:"likes"`
+	Tags        []string `json:"tags,omitempty"`
+	Gated       string   `json:"gated"`
+	Private     bool     `json:"private"`
+}
+
+// Details fetches the metadata of model. A non-empty revision also resolves the
+// commit it points to into Sha.
+func (c *Client) Details(ctx context.Context, model, revision string) (ModelDetails, error) {
+	url := fmt.Sprintf("%s/api/models/%s", c.Endpoint, model)
+	if revision != "" {
+		url += "/revision/" + neturl.PathEscape(revision)
+	}
+
+	var apiResponse struct {
+		ID          string          `json:"id"`
+		Sha         string          `json:"sha"`
+		PipelineTag string          `json:"pipeline_tag"`
+		Library     string          `json:"library_name"`
+		Downloads   int64           `json:"downloads"`
+		Likes       int64           `json:"likes"`
+		Tags        []string        `json:"tags"`
+		Gated       json.RawMessage `json:"gated"`
+		Private     bool            `json:"private"`
+		CardData    struct {
+			License string `json:"license"`
+		} `json:"cardData"`
+	}
+	if err := c.getJSON(ctx, url, "model info", &apiResponse); err != nil {
+		return ModelDetails{}, err
+	}
+
+	details := ModelDetails{
+		ID:          apiResponse.ID,
+		Sha:         apiResponse.Sha,
+		PipelineTag: apiResponse.PipelineTag,
+		Library:     apiResponse.Library,
+		License:     apiResponse.CardData.License,
+		Downloads:   apiResponse.Downloads,
+		Likes:       apiResponse.Likes,
+		Tags:        apiResponse.Tags,
+		Gated:       parseGated(apiResponse.Gated),
+		Private:     apiResponse.Private,
+	}
+
+	// Older model cards only carry the license as a "license:<id>" tag
+	if details.License == "" {
+		for _, tag := range details.Tags {
+			if strings.HasPrefix(tag, "license:") {
+				details.License = strings.TrimPrefix(tag, "license:")
+				break
+			}
+		}
+	}
+
+	return details, nil
+}
+
+// parseGated normalizes the API's gated field, which is either false or "auto"/"manual"
+func parseGated(raw json.RawMessage) string {
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil && mode != "" {
+		return mode
+	}
+	var gated bool
+	if err := json.Unmarshal(raw, &gated); err == nil && gated {
+		return "true"
+	}
+	return "false"
+}
+
+// GitRef is a branch, tag or other ref of a model repo
+type GitRef struct {
+	Name         string `json:"name"`
+	Ref          string `json:"ref"`
+	TargetCommit string `json:"targetCommit"`
+}
+
+// ModelRefs groups a model repo's refs by kind
+type ModelRefs struct {
+	Branches     []GitRef `json:"branches"`
+	Tags         []GitRef `json:"tags"`
+	Converts     []GitRef `json:"converts"`
+	PullRequests []GitRef `json:"pullRequests"`
+}
+
+// Refs fetches the branches, tags, converts and pull request refs of model
+func (c *Client) Refs(ctx context.Context, model string) (ModelRefs, error) {
+	var refs ModelRefs
+	err := c.getJSON(ctx, fmt.Sprintf("%s/api/models/%s/refs?include_prs=1", c.Endpoint, model), "refs", &refs)
+	return refs, err
+}
+
+// TreeOptions tunes Client.ListTree
+type TreeOptions struct {
+	// CachePath, if set, caches single-page listings with their ETag there and
+	// revalidates them with If-None-Match, reusing the cached list on 304
+	CachePath string
+	// OnPage, if set, is called with the number of files each page adds
+	OnPage func(n int)
+}
+
+// TreeCacheName is the sidecar file caching the listing of revision
+func TreeCacheName(revision string) string {
+	return ".hugdl-tree-" + strings.ReplaceAll(revision, "/", "_") + ".json"
+}
+
+// treeCache is a cached tree listing and the ETag it was served with
+type treeCache struct {
+	ETag  string `json:"etag"`
+	Files []File `json:"files"`
+}
+
+// ListTree returns the whole listing of model at revision, following its pages.
+// Unlike ListFiles it keeps symlinks and submodules with their Type.
+func (c *Client) ListTree(ctx context.Context, model, revision string, opts TreeOptions) ([]File, error) {
+	onPage := func(n int) {
+		if opts.OnPage != nil {
+			opts.OnPage(n)
+		}
+	}
+	cached := loadTreeCache(opts.CachePath)
+
+	var files []File
+	first := true
+	seen := map[string]bool{}
+	for url := c.TreeURL(model, revision); url != ""; {
+		// A server sending a page's own link as "next" must not keep us here forever
+		if seen[url] {
+			return nil, fmt.Errorf("listing pages loop back to %s", url)
+		}
+		seen[url] = true
+
+		etag := ""
+		if first && cached != nil {
+			etag = cached.ETag
+		}
+		page, err := c.ListPage(ctx, url, etag)
+		if err != nil {
+			return nil, err
+		}
+		if page.NotModified {
+			onPage(len(cached.Files))
+			return cached.Files, nil
+		}
+
+		// Only complete single-page listings are cached: a later page can change
+		// without the first page's ETag changing.
+		if first && page.Next == "" && page.ETag != "" && opts.CachePath != "" {
+			saveTreeCache(opts.CachePath, treeCache{ETag: page.ETag, Files: page.Files})
+		}
+
+		files = append(files, page.Files...)
+		onPage(len(page.Files))
+		url = page.Next
+		first = false
+	}
+	return files, nil
+}
+
+// loadTreeCache reads a cached listing, returning nil if there is none
+func loadTreeCache(path string) *treeCache {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cache treeCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.ETag == "" {
+		return nil
+	}
+	return &cache
+}
+
+// saveTreeCache writes a listing cache; failures only cost a refetch next time
+func saveTreeCache(path string, cache treeCache) {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	os.WriteFile(path, data, 0644)
+}
+
+// ShardOrder downloads the shard indexes among files and returns the repo paths of
+// their shards in the order the weight maps first reference them
+func (c *Client) ShardOrder(ctx context.Context, model, revision string, files []File) ([]string, error) {
+	var shards []string
+	for _, file := range files {
+		if !isShardIndex(file.Path) {
+			continue
+		}
+		resp, err := c.get(ctx, c.resolveURL(model, revision, file.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", file.Path, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, newStatusError(resp)
+		}
+
+		names, err := parseShardOrder(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Path, err)
+		}
+		dir := pathpkg.Dir(file.Path)
+		for _, name := range names {
+			shards = append(shards, pathpkg.Join(dir, name))
+		}
+	}
+	return shards, nil
+}
+
+// ApplyGitAttributes downloads the repo's .gitattributes and marks matching files as LFS.
+// It returns how many files were newly marked; a repo without the file is not an error.
+func (c *Client) ApplyGitAttributes(ctx context.Context, model, revision string, files []File) (int, error) {
+	resp, err := c.get(ctx, c.resolveURL(model, revision, ".gitattributes"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch .gitattributes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, newStatusError(resp)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+
+	patterns := parseGitAttributes(string(content))
+	marked := 0
+	for i := range files {
+		if !files[i].LFS && matchesLFS(patterns, files[i].Path) {
+			files[i].LFS = true
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+// Manifest reads a file list from an external JSON array of {path, size, oid}
+// used in place of the tree API. A 64-character oid is taken as the SHA256 of an LFS
+// file, anything else as a git blob id. If key is set, the manifest is only used when
+// the detached signature at sigURL verifies over its exact bytes.
+func (c *Client) Manifest(ctx context.Context, url string, key ed25519.PublicKey, sigURL string) ([]File, error) {
+	body, err := c.fetchSmall(ctx, url, "manifest")
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		sig, err := c.fetchSmall(ctx, sigURL, "manifest signature")
+		if err != nil {
+			return nil, err
+		}
+		if err := VerifyManifestSignature(body, sig, key); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+		Oid  string `json:"oid"`
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	files := make([]File, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Path == "" {
+			return nil, fmt.Errorf("manifest entry %d has no path", i)
+		}
+		file := File{Type: TypeFile, Size: entry.Size, Path: entry.Path}
+		if len(entry.Oid) == sha256.Size*2 {
+			file.LFS = true
+			file.LFSOid = entry.Oid
+		} else {
+			file.Oid = entry.Oid
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// fetchSmall downloads a small document such as a manifest into memory, sending the
+// credentials configured for its host
+func (c *Client) fetchSmall(ctx context.Context, url, what string) ([]byte, error) {
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status: %d", what, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	return body, nil
+}
+
+// ParseManifestKey decodes a base64 ed25519 public key given directly or as the
+// content of a file
+func ParseManifestKey(value string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		content, rerr := os.ReadFile(value)
+		if rerr != nil {
+			return nil, fmt.Errorf("%q is neither a base64 key nor a readable key file", value)
+		}
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, fmt.Errorf("%s does not contain a base64 key: %w", value, err)
+		}
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public keys are %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifyManifestSignature checks a detached ed25519 signature over the manifest's
+// bytes. The signature may be raw (64 bytes) or base64 text.
+func VerifyManifestSignature(manifest, sig []byte, key ed25519.PublicKey) error {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil || len(decoded) != ed25519.SignatureSize {
+			return fmt.Errorf("manifest signature is not a %d-byte ed25519 signature", ed25519.SignatureSize)
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(key, manifest, sig) {
+		return errors.New("manifest signature does not match the key; refusing to trust the manifest")
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Package hugdl lists and downloads HuggingFace model repos from Go programs.
+//
+// It is the core of the hugdl command without the terminal output: every function
+// returns its errors and nothing is printed or exits the process.
+//
+//	client := hugdl.NewClient()
+//	files, err := client.Download(ctx, "Qwen/Qwen2.5-Coder-0.5B", hugdl.DownloadOptions{
+//		OutputDir: "models/qwen",
+//		Filter:    func(f hugdl.File) bool { return !f.LFS },
+//	})
+//
+// Files are written to <name>.part first and renamed into place once their
+// content matches the repo's hash, so a cancelled download never leaves a
+// truncated file under the final name. A later download of the same file
+// resumes the .part with a range request.
+package hugdl
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	neturl "net/url"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// DefaultEndpoint is the Hub used by NewClient
+const DefaultEndpoint = "https://huggingface.co"
+
+// DefaultRevision is downloaded when no revision is given
+const DefaultRevision = "main"
+
+// Tree entry types kept in a listing; directories are dropped
+const (
+	TypeFile      = "file"
+	TypeSymlink   = "symlink"
+	TypeSubmodule = "submodule" // git reports these as "commit"
+)
+
+// File is one entry of a repo tree
+type File struct {
+	// Type is TypeFile, TypeSymlink or TypeSubmodule
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size,omitempty"`
+	// Oid is the git blob id; LFSOid is the SHA256 of LFS-tracked content
+	Oid    string `json:"oid,omitempty"`
+	LFSOid string `json:"lfs_oid,omitempty"`
+	LFS    bool   `json:"lfs,omitempty"`
+}
+
+// Name returns the file name without its directory
+func (f File) Name() string {
+	return pathpkg.Base(f.Path)
+}
+
+// ExpectedOid returns the hash a local copy must have: the SHA256 for LFS files,
+// the git blob id otherwise
+func (f File) ExpectedOid() string {
+	if f.LFS {
+		return f.LFSOid
+	}
+	return f.Oid
+}
+
+// Credentials are sent to one host: a bearer token, extra headers or both
+type Credentials struct {
+	Token   string            `json:"token"`
+	Headers map[string]string `json:"headers"`
+}
+
+// apply sets the token and headers on req
+func (c Credentials) apply(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// Client talks to a HuggingFace Hub. The zero value is not usable; use NewClient.
+// A Client is safe for concurrent use once its fields are set.
+type Client struct {
+	// Endpoint is the Hub's base URL, e.g. https://huggingface.co
+	Endpoint string
+	// Token is sent as a bearer token for gated and private repos; empty sends none
+	Token string
+	// Credentials replace Token for the hosts they are keyed by: lower-case host
+	// names, optionally with a port. An entry with the port wins over the bare name.
+	Credentials map[string]Credentials
+	// HTTPClient sends every request. NewClient's client switches credentials when a
+	// redirect changes host; a custom client should use CheckRedirect to do the same.
+	// Downloads use its Transport with their own redirect handling.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the public Hub, using $HF_TOKEN if it is set
+func NewClient() *Client {
+	c := &Client{
+		Endpoint: DefaultEndpoint,
+		Token:    os.Getenv("HF_TOKEN"),
+	}
+	c.HTTPClient = &http.Client{CheckRedirect: c.CheckRedirect}
+	return c
+}
+
+// credentialsFor returns the Credentials entry for u's host, preferring
+// an entry with the port over one for the bare host name
+func (c *Client) credentialsFor(u *neturl.URL) (Credentials, bool) {
+	if creds, ok := c.Credentials[strings.ToLower(u.Host)]; ok {
+		return creds, true
+	}
+	creds, ok := c.Credentials[strings.ToLower(u.Hostname())]
+	return creds, ok
+}
+
+// Authorize adds the credentials for the request's host: its Credentials
+// entry if there is one, otherwise the Token, if any.
+func (c *Client) Authorize(req *http.Request) {
+	if creds, ok := c.credentialsFor(req.URL); ok {
+		creds.apply(req)
+		return
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// CheckRedirect is an http.Client redirect policy that switches credentials when a
+// redirect leaves the previous host, like huggingface_hub does. The default policy keeps
+// Authorization on redirects to subdomains, so LFS redirects from huggingface.co to
+// cdn-lfs.huggingface.co would carry the token to a CDN that rejects it; the headers
+// of a Credentials entry must not leak to other hosts either. The Token is never
+// sent after a host change; only the new host's own Credentials are.
+func (c *Client) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	prev := via[len(via)-1]
+	if strings.EqualFold(prev.URL.Host, req.URL.Host) {
+		return nil
+	}
+	req.Header.Del("Authorization")
+	if creds, ok := c.credentialsFor(prev.URL); ok {
+		for name := range creds.Headers {
+			req.Header.Del(name)
+		}
+	}
+	if creds, ok := c.credentialsFor(req.URL); ok {
+		creds.apply(req)
+	}
+	return nil
+}
+
+// StatusError is returned when the Hub answers with an unexpected HTTP status
+type StatusError struct {
+	URL  string
+	Code int
+	// Authorized reports whether the failed request carried a token
+	Authorized bool
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s returned status %d", e.URL, e.Code)
+	if e.Code != http.StatusUnauthorized && e.Code != http.StatusForbidden {
+		return msg
+	}
+	if e.Authorized {
+		return msg + " (the access token is invalid or lacks access to this repo; gated models must be accepted on their page)"
+	}
+	return msg + " (the repo may be gated or private and needs an access token, e.g. from HF_TOKEN)"
+}
+
+// newStatusError describes resp's unexpected status
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        resp.Request.URL.String(),
+		Code:       resp.StatusCode,
+		Authorized: resp.Request.Header.Get("Authorization") != "",
+	}
+}
+
+// ChecksumError is returned when downloaded content does not match the repo's hash
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// newRequest builds an authorized request bound to ctx
+func (c *Client) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.Authorize(req)
+	return req, nil
+}
+
+// NewOidHash returns a hash producing the Hub oid of content with the given size:
+// SHA256 for LFS files, the git blob SHA1 otherwise
+func NewOidHash(lfs bool, size int64) hash.Hash {
+	if lfs {
+		return sha256.New()
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	return h
+}
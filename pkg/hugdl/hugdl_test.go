@@ -0,0 +1,2634 @@
+package hugdl
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// testRepo is a fake Hub serving one repo, org/m, at revision main
+type testRepo struct {
+	files map[string][]byte
+	lfs   map[string]bool
+	// serve, if set, answers a resolve request for path instead of the default handler
+	serve func(w http.ResponseWriter, r *http.Request, path string) bool
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// oid returns the Hub oid of path's content
+func (repo *testRepo) oid(path string) string {
+	content := repo.files[path]
+	h := NewOidHash(repo.lfs[path], int64(len(content)))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// file returns the listing entry of path
+func (repo *testRepo) file(path string) File {
+	file := File{Type: TypeFile, Path: path, Size: int64(len(repo.files[path]))}
+	if repo.lfs[path] {
+		file.LFS = true
+		file.LFSOid = repo.oid(path)
+	} else {
+		file.Oid = repo.oid(path)
+	}
+	return file
+}
+
+func (repo *testRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repo.mu.Lock()
+	repo.requests = append(repo.requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Range"))
+	repo.mu.Unlock()
+
+	if r.URL.Path == "/api/models/org/m/tree/main" {
+		var items []map[string]any
+		for path := range repo.files {
+			file := repo.file(path)
+			item := map[string]any{"type": "file", "path": path, "size": file.Size, "oid": file.Oid}
+			if file.LFS {
+				item["oid"] = strings.Repeat("0", 40)
+				item["lfs"] = map[string]any{"oid": file.LFSOid}
+			}
+			items = append(items, item)
+		}
+		items = append(items, map[string]any{"type": "directory", "path": "sub", "oid": "x"})
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/org/m/resolve/main/")
+	content, found := repo.files[path]
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+	if repo.serve != nil && repo.serve(w, r, path) {
+		return
+	}
+	if repo.lfs[path] {
+		w.Header().Set("X-Linked-Etag", `"`+repo.oid(path)+`"`)
+	} else {
+		w.Header().Set("ETag", `"`+repo.oid(path)+`"`)
+	}
+	w.Header().Set("X-Repo-Commit", "c0ffee")
+	if rng := r.Header.Get("Range"); rng != "" {
+		first, last, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+		start, _ := strconv.Atoi(first)
+		end, err := strconv.Atoi(last)
+		if err != nil {
+			end = len(content) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+		return
+	}
+	// Go only works out the length of bodies it sends, not of HEAD responses
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+// newTestClient starts repo and returns a Client pointed at it
+func newTestClient(t *testing.T, repo *testRepo) *Client {
+	t.Helper()
+	server := httptest.NewServer(repo)
+	t.Cleanup(server.Close)
+	client := NewClient()
+	client.Endpoint = server.URL
+	client.Token = ""
+	return client
+}
+
+func TestNextPageURL(t *testing.T) {
+	tests := map[string]string{
+		"": "",
+		`<https://hub/api/tree?cursor=2>; rel="next"`:                      "https://hub/api/tree?cursor=2",
+		`<https://hub/first>; rel="first", <https://hub/next>; rel="next"`: "https://hub/next",
+		`<https://hub/prev>; rel="prev"`:                                   "",
+		`<https://hub/next>; title="x"; rel="next"`:                        "https://hub/next",
+		`https://hub/no-params`:                                            "",
+	}
+	for link, want := range tests {
+		if got := nextPageURL(link); got != want {
+			t.Errorf("nextPageURL(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestListFilesFollowsPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?recursive=true&cursor=2>; rel="next"`, server.URL, r.URL.Path))
+			fmt.Fprint(w, `[{"type":"file","path":"config.json","size":2,"oid":"a"},{"type":"directory","path":"onnx","oid":"d"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"type":"file","path":"onnx/model.onnx","size":9,"oid":"b","lfs":{"oid":"c"}},{"type":"commit","path":"vendor","oid":"e"}]`)
+		}
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	files, err := client.ListFiles(context.Background(), "org/m", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{Type: TypeFile, Path: "config.json", Size: 2, Oid: "a"},
+		{Type: TypeFile, Path: "onnx/model.onnx", Size: 9, Oid: "b", LFSOid: "c", LFS: true},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("ListFiles = %+v, want %+v", files, want)
+	}
+
+	page, err := client.ListPage(context.Background(), client.TreeURL("org/m", "refs/pr/3")+"&cursor=2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Files) != 2 || page.Files[1].Type != TypeSubmodule || page.Next != "" {
+		t.Errorf("second page = %+v, want the LFS file and a submodule without a next page", page)
+	}
+	if want := "/tree/refs%2Fpr%2F3?"; !strings.Contains(client.TreeURL("org/m", "refs/pr/3"), want) {
+		t.Errorf("TreeURL does not keep the revision in one segment: %s", client.TreeURL("org/m", "refs/pr/3"))
+	}
+}
+
+func TestListFilesStopsOnPageLoop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, r.URL.RequestURI()))
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	if _, err := client.ListFiles(context.Background(), "org/m", "main"); err == nil || !strings.Contains(err.Error(), "loop back") {
+		t.Fatalf("ListFiles = %v, want a page loop error", err)
+	}
+}
+
+func TestListPageStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, Token: "secret", HTTPClient: server.Client()}
+
+	page, err := client.ListPage(context.Background(), client.TreeURL("org/m", "main"), `"v1"`)
+	if err != nil || !page.NotModified {
+		t.Errorf("ListPage with a matching ETag = %+v, %v; want not modified", page, err)
+	}
+	_, err = client.ListPage(context.Background(), client.TreeURL("org/m", "main"), "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnauthorized || !statusErr.Authorized {
+		t.Fatalf("ListPage = %v, want an authorized 401 StatusError", err)
+	}
+	if !strings.Contains(err.Error(), "lacks access") {
+		t.Errorf("401 with a token should blame the token: %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"0", 0, true},
+		{"-3", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+		{"86400", maxRetryAfter, true},
+		{"99999999999999999", maxRetryAfter, true},
+		{time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), maxRetryAfter, true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(90*time.Second).UTC().Format(http.TimeFormat))
+	if got, ok := retryAfter(header); !ok || got < 80*time.Second || got > 90*time.Second {
+		t.Errorf("retryAfter(date in 90s) = %v, %v", got, ok)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if got := backoffDelay(attempt); got < base || got > base+base/2 {
+				t.Fatalf("backoffDelay(%d) = %v, want %v plus up to 50%%", attempt, got, base)
+			}
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	client := &Client{
+		Token: "hub-token",
+		Credentials: map[string]Credentials{
+			"mirror.example:8443": {Token: "mirror-token", Headers: map[string]string{"X-Mirror": "1"}},
+			"cdn.example":         {Headers: map[string]string{"X-Cdn": "1"}},
+		},
+	}
+	tests := []struct {
+		from, to   string
+		wantAuth   string
+		wantHeader map[string]string
+	}{
+		// Same host keeps the token
+		{"https://huggingface.co/a", "https://huggingface.co/b", "Bearer hub-token", nil},
+		// Subdomains are other hosts: the CDN must not see the Hub token
+		{"https://huggingface.co/a", "https://cdn-lfs.huggingface.co/b", "", nil},
+		// Host comparison ignores case
+		{"https://huggingface.co/a", "https://HuggingFace.co/b", "Bearer hub-token", nil},
+		// A redirect picks up the new host's credentials and drops the previous host's headers
+		{"https://mirror.example:8443/a", "https://cdn.example/b", "", map[string]string{"X-Mirror": "", "X-Cdn": "1"}},
+		// The port is part of the host
+		{"https://mirror.example:8443/a", "https://mirror.example/b", "", map[string]string{"X-Mirror": ""}},
+	}
+	for _, tt := range tests {
+		prev, _ := http.NewRequest("GET", tt.from, nil)
+		client.Authorize(prev)
+		req, _ := http.NewRequest("GET", tt.to, nil)
+		req.Header = prev.Header.Clone()
+		if err := client.CheckRedirect(req, []*http.Request{prev}); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+			t.Errorf("%s -> %s: Authorization = %q, want %q", tt.from, tt.to, got, tt.wantAuth)
+		}
+		for name, want := range tt.wantHeader {
+			if got := req.Header.Get(name); got != want {
+				t.Errorf("%s -> %s: %s = %q, want %q", tt.from, tt.to, name, got, want)
+			}
+		}
+	}
+
+	via := make([]*http.Request, 10)
+	if err := client.CheckRedirect(&http.Request{}, via); err == nil {
+		t.Error("CheckRedirect followed an 11th redirect")
+	}
+}
+
+func TestIsLFSPointer(t *testing.T) {
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a\nsize 12345\n"
+	if !isLFSPointer([]byte(pointer)) {
+		t.Error("a pointer file was not recognized")
+	}
+	if isLFSPointer([]byte("{\"version\": 1}")) {
+		t.Error("JSON content was taken for a pointer")
+	}
+	if isLFSPointer([]byte(pointer + strings.Repeat("x", lfsPointerMaxSize))) {
+		t.Error("content longer than any pointer was taken for a pointer")
+	}
+
+	head := &headWriter{max: 4}
+	head.Write([]byte("abc"))
+	head.Write([]byte("defgh"))
+	if string(head.buf) != "abcde" {
+		t.Errorf("headWriter kept %q, want the first max+1 bytes", head.buf)
+	}
+}
+
+func TestDownloadFileRejectsLFSPointer(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"model.bin": []byte(strings.Repeat("w", 4096))}, lfs: map[string]bool{"model.bin": true}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		fmt.Fprint(w, "version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 4096\n")
+		return true
+	}
+	client := newTestClient(t, repo)
+	outputPath := filepath.Join(t.TempDir(), "model.bin")
+
+	_, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("model.bin"), []string{outputPath}, FileOptions{})
+	if err == nil || !strings.Contains(err.Error(), "Git LFS pointer") {
+		t.Fatalf("DownloadFile = %v, want the pointer to be rejected", err)
+	}
+	if _, err := os.Stat(outputPath + PartSuffix); !os.IsNotExist(err) {
+		t.Error("the pointer was kept as a partial download")
+	}
+}
+
+func TestDownloadFileResumes(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 300))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	dirs := []string{t.TempDir(), t.TempDir()}
+	outputPaths := []string{filepath.Join(dirs[0], "model.bin"), filepath.Join(dirs[1], "model.bin")}
+
+	// The mirrors stopped at different points; the download resumes from the shorter one
+	os.WriteFile(outputPaths[0]+PartSuffix, content[:1000], 0644)
+	os.WriteFile(outputPaths[1]+PartSuffix, content[:1500], 0644)
+	if got := ResumeOffset(outputPaths, int64(len(content))); got != 1000 {
+		t.Fatalf("ResumeOffset = %d, want 1000", got)
+	}
+
+	var events []EventKind
+	var seen int
+	result, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("model.bin"), outputPaths, FileOptions{
+		Writers: []io.Writer{writerFunc(func(p []byte) { seen += len(p) })},
+		OnEvent: func(e Event) {
+			events = append(events, e.Kind)
+			if e.Kind == EventStart && e.Offset != 1000 {
+				t.Errorf("start event at %d, want 1000", e.Offset)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Commit != "c0ffee" || result.ETag != repo.oid("model.bin") {
+		t.Errorf("result = %+v", result)
+	}
+	for _, outputPath := range outputPaths {
+		if got, _ := os.ReadFile(outputPath); string(got) != string(content) {
+			t.Errorf("%s does not hold the file", outputPath)
+		}
+	}
+	if seen != len(content) {
+		t.Errorf("writers saw %d bytes, want the whole file including the resumed prefix", seen)
+	}
+	if last := repo.requests[len(repo.requests)-1]; !strings.HasSuffix(last, "bytes=1000-") {
+		t.Errorf("request %q did not resume at 1000", last)
+	}
+	if fmt.Sprint(events) != fmt.Sprint([]EventKind{EventStart, EventVerified, EventVerified, EventDone}) {
+		t.Errorf("events = %v", events)
+	}
+}
+
+func TestResumeHashesPrefix(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 300))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	file := repo.file("model.bin")
+
+	// The final hash covers the prefix on disk: a damaged prefix fails verification
+	// with the hash of exactly what would have been kept
+	outputPath := filepath.Join(t.TempDir(), "model.bin")
+	damaged := append([]byte("X"), content[1:1000]...)
+	os.WriteFile(outputPath+PartSuffix, damaged, 0644)
+	_, err := client.DownloadFile(context.Background(), "org/m", "main", file, []string{outputPath}, FileOptions{})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || sumErr.Actual != testOid(append(damaged, content[1000:]...), true) {
+		t.Fatalf("resuming a damaged prefix = %v, want a checksum error over the whole assembled file", err)
+	}
+
+	// A sound prefix is hashed once while it is read back, and the verified result
+	// is trusted afterwards without hashing the file again
+	dest := t.TempDir()
+	layout, _ := NewLayout(LayoutNested, dest, "org/m", "main", "")
+	os.MkdirAll(layout.ModelDir, 0755)
+	os.WriteFile(filepath.Join(layout.ModelDir, "model.bin")+PartSuffix, content[:1000], 0644)
+	hasher := NewHasher(0, LoadChecksumCache(t.TempDir()))
+	var seen int
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{
+		Model:       "org/m",
+		Layouts:     []Layout{layout},
+		Planner:     Planner{Hasher: hasher},
+		FileOptions: FileOptions{Writers: []io.Writer{writerFunc(func(p []byte) { seen += len(p) })}},
+	})
+	if err != nil || report.Files[0].Bytes != int64(len(content)-1000) {
+		t.Fatalf("DownloadAll = %v, transferred %d; want the rest of the file resumed", err, report.Files[0].Bytes)
+	}
+	if seen != len(content) {
+		t.Errorf("the hash and writers saw %d bytes, want each byte of the file once", seen)
+	}
+	results := VerifyLocalFiles(layout.ModelDir, []File{file}, 1, hasher)
+	if results[0].Err != nil || hasher.Cache().Hits() != 1 {
+		t.Errorf("verifying after the resume = %v with %d cache hits, want the download's own hash trusted", results[0].Err, hasher.Cache().Hits())
+	}
+}
+
+func TestDownloadFileContinuesDroppedConnection(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefgh", 1000))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}}
+	drops := 1
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if drops == 0 {
+			return false
+		}
+		drops--
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:3000])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	client := newTestClient(t, repo)
+	outputPath := filepath.Join(t.TempDir(), "model.bin")
+
+	var retries []Event
+	opts := FileOptions{Retries: 1, OnEvent: func(e Event) {
+		if e.Kind == EventRetry {
+			e.Delay = 0
+			retries = append(retries, e)
+		}
+	}}
+	if _, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("model.bin"), []string{outputPath}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(outputPath); string(got) != string(content) {
+		t.Error("the continued download does not hold the file")
+	}
+	if len(retries) != 1 || retries[0].Attempt != 2 || retries[0].Max != 2 {
+		t.Errorf("retries = %+v, want one retry reported as attempt 2/2", retries)
+	}
+	if last := repo.requests[len(repo.requests)-1]; !strings.HasSuffix(last, "bytes=3000-") {
+		t.Errorf("request %q did not continue at 3000", last)
+	}
+}
+
+func TestDownloadChecksumError(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "README.md": []byte("hi")}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "config.json" {
+			return false
+		}
+		w.Write([]byte(`{"a":2}`))
+		return true
+	}
+	client := newTestClient(t, repo)
+	dir := t.TempDir()
+
+	files, err := client.Download(context.Background(), "org/m", DownloadOptions{OutputDir: dir, Concurrency: 1})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || sumErr.Expected != repo.oid("config.json") {
+		t.Fatalf("Download = %v, want a ChecksumError for config.json", err)
+	}
+	if len(files) != 1 || files[0].Path != "README.md" {
+		t.Errorf("downloaded %+v, want only README.md", files)
+	}
+	for _, name := range []string{"config.json", "config.json" + PartSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", name)
+		}
+	}
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
+
+func TestDownloadAll(t *testing.T) {
+	repo := &testRepo{
+		files: map[string][]byte{
+			"config.json":  []byte(`{"a":1}`),
+			"README.md":    []byte("# m\n"),
+			"model.bin":    []byte(strings.Repeat("weights", 500)),
+			"sub/tok.json": []byte(`{"t":2}`),
+		},
+		lfs: map[string]bool{"model.bin": true},
+	}
+	client := newTestClient(t, repo)
+	ctx := context.Background()
+	statuses := func(report *Report) map[string]FileStatus {
+		got := map[string]FileStatus{}
+		for _, result := range report.Files {
+			got[result.File.Path] = result.Status
+		}
+		return got
+	}
+
+	// A fresh download transfers every file and says where it came from
+	dest := t.TempDir()
+	report, err := client.DownloadAll(ctx, DownloadAllOptions{Model: "org/m", Dest: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 4 || report.Count(StatusDownloaded) != 4 {
+		t.Fatalf("first run = %v, want 4 downloads", statuses(report))
+	}
+	for _, result := range report.Files {
+		if result.Bytes != result.File.Size || result.Commit != "c0ffee" || result.Duration <= 0 {
+			t.Errorf("%s: %+v, want %d bytes from c0ffee with a duration", result.File.Path, result, result.File.Size)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(result.File.Path))); string(got) != string(repo.files[result.File.Path]) {
+			t.Errorf("%s does not hold the file", result.File.Path)
+		}
+	}
+
+	// Present files are skipped, unless forced
+	report, err = client.DownloadAll(ctx, DownloadAllOptions{Model: "org/m", Dest: dest, Concurrency: 1})
+	if err != nil || report.Count(StatusSkipped) != 4 {
+		t.Errorf("second run = %v, %v; want every file skipped", statuses(report), err)
+	}
+	for _, result := range report.Files {
+		if result.Bytes != 0 {
+			t.Errorf("skipped %s counts %d bytes", result.File.Path, result.Bytes)
+		}
+	}
+	report, err = client.DownloadAll(ctx, DownloadAllOptions{Model: "org/m", Dest: dest, Force: true, Filter: func(f File) bool { return !f.LFS }})
+	if err != nil || len(report.Files) != 3 || report.Count(StatusDownloaded) != 3 {
+		t.Errorf("forced run of the small files = %v, %v; want 3 downloads", statuses(report), err)
+	}
+}
+
+func TestDownloadAllResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 300))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+
+	for _, noResume := range []bool{false, true} {
+		dest := t.TempDir()
+		os.WriteFile(filepath.Join(dest, "model.bin"+PartSuffix), content[:1000], 0644)
+		var progress []int64
+		report, err := client.DownloadAll(context.Background(), DownloadAllOptions{
+			Model:    "org/m",
+			Dest:     dest,
+			NoResume: noResume,
+			Progress: func(file File, written int64) { progress = append(progress, written) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := report.Files[0]
+		wantBytes, wantRange := int64(2000), "bytes=1000-"
+		if noResume {
+			wantBytes, wantRange = int64(len(content)), ""
+		}
+		if result.Status != StatusDownloaded || result.Bytes != wantBytes {
+			t.Errorf("NoResume=%v: %+v, want %d bytes transferred", noResume, result, wantBytes)
+		}
+		if last := repo.requests[len(repo.requests)-1]; !strings.HasSuffix(last, " "+wantRange) {
+			t.Errorf("NoResume=%v: request %q, want range %q", noResume, last, wantRange)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dest, "model.bin")); string(got) != string(content) {
+			t.Errorf("NoResume=%v: the file is not complete", noResume)
+		}
+		// The progress hook counts the whole file, including a resumed prefix
+		if len(progress) == 0 || progress[len(progress)-1] != int64(len(content)) {
+			t.Errorf("NoResume=%v: progress ends at %v, want %d", noResume, progress, len(content))
+		}
+	}
+}
+
+func TestDownloadAllVerify(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "README.md": []byte("hi")}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "config.json" {
+			return false
+		}
+		w.Write([]byte(`{"a":2}`))
+		return true
+	}
+	client := newTestClient(t, repo)
+
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), Concurrency: 2})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) {
+		t.Fatalf("DownloadAll = %v, want a ChecksumError", err)
+	}
+	for _, result := range report.Files {
+		want := StatusDownloaded
+		if result.File.Path == "config.json" {
+			want = StatusFailed
+		}
+		if result.Status != want || (want == StatusFailed) != (result.Err != nil) {
+			t.Errorf("%s: %s (%v), want %s", result.File.Path, result.Status, result.Err, want)
+		}
+	}
+
+	report, err = client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), NoVerify: true})
+	if err != nil || report.Count(StatusDownloaded) != 2 {
+		t.Errorf("DownloadAll with NoVerify = %v, %v; want both files kept", report.Files, err)
+	}
+}
+
+func TestDownloadAllCancelled(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"a.json": []byte("{}"), "b.json": []byte("[]"), "c.json": []byte(`""`)}}
+	client := newTestClient(t, repo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first file cancels the run once it is written; the others never start
+	report, err := client.DownloadAll(ctx, DownloadAllOptions{
+		Model:       "org/m",
+		Dest:        t.TempDir(),
+		Concurrency: 1,
+		Filter:      func(f File) bool { return true },
+		Progress:    func(File, int64) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DownloadAll = %v, want the cancellation", err)
+	}
+	if report == nil || len(report.Files) != 3 || report.Count(StatusNotStarted) != 2 {
+		t.Errorf("report = %+v, want two files not started", report)
+	}
+
+	if _, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m"}); err == nil {
+		t.Error("DownloadAll without Dest succeeded")
+	}
+}
+
+// testOid hashes content the way the Hub does for a file of the given kind
+func testOid(content []byte, lfs bool) string {
+	h := NewOidHash(lfs, int64(len(content)))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeTestFile writes content to name under dir, creating parent directories
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNormalizedFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("{\n  \"a\": 1\n}\n")
+	file := File{Type: TypeFile, Path: "config.json", Size: int64(len(content)), Oid: testOid(content, false)}
+	path := writeTestFile(t, dir, file.Path, content)
+
+	changed, err := normalizeLineEndings(path, LineEndingsCRLF)
+	if err != nil || !changed {
+		t.Fatalf("normalizeLineEndings = %v, %v; want true, nil", changed, err)
+	}
+	state := LoadState(dir, "org/m", "main")
+	state.record(file, Result{})
+	var mu sync.Mutex
+	if err := state.recordLocal(file, path, nil, &mu); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run finds the normalized copy complete and intact
+	saved := LoadState(dir, "org/m", "main")
+	for _, p := range []Planner{{State: saved}, {State: saved, DeepSync: true}} {
+		if plan := p.Plan(file, []string{path}); plan.Action != ActionSkip {
+			t.Errorf("plan with DeepSync=%v = %s (%s), want skip", p.DeepSync, plan.Action, plan.Reason)
+		}
+	}
+	for _, result := range VerifyLocalFiles(dir, []File{saved.LocalFile(file)}, 1, nil) {
+		if result.Err != nil {
+			t.Errorf("verifying the normalized copy: %v", result.Err)
+		}
+	}
+
+	// Without the record the copy no longer matches the repo
+	if plan := (Planner{}).Plan(file, []string{path}); plan.Action != ActionDownload {
+		t.Errorf("plan without state = %s, want download", plan.Action)
+	}
+
+	// New upstream content is downloaded even though the old normalized copy is intact
+	updated := file
+	updated.Oid = testOid([]byte("{}\n"), false)
+	if plan := (Planner{State: saved}).Plan(updated, []string{path}); plan.Action != ActionDownload {
+		t.Errorf("plan after an upstream change = %s, want download", plan.Action)
+	}
+}
+
+func TestStateMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("{\n  \"a\": 1\n}\n")
+	file := File{Type: TypeFile, Path: "config.json", Size: int64(len(content)), Oid: testOid(content, false)}
+	path := writeTestFile(t, dir, file.Path, content)
+	if _, err := normalizeLineEndings(path, LineEndingsCRLF); err != nil {
+		t.Fatal(err)
+	}
+	state := LoadState(dir, "org/m", "main")
+	state.record(file, Result{})
+	var mu sync.Mutex
+	if err := state.recordLocal(file, path, nil, &mu); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh entry is trusted, and survives a round trip with its timestamp
+	saved := LoadState(dir, "org/m", "main")
+	saved.SetMaxAge(time.Hour)
+	if saved.Files[file.Path].RecordedAt.IsZero() {
+		t.Fatal("the saved entry has no timestamp")
+	}
+	if plan := (Planner{State: saved}).Plan(file, []string{path}); plan.Action != ActionSkip {
+		t.Errorf("plan with a fresh entry = %s (%s), want skip", plan.Action, plan.Reason)
+	}
+
+	// An expired entry falls back to the repo's size and hash, which the normalized copy no longer has
+	entry := saved.Files[file.Path]
+	entry.RecordedAt = time.Now().Add(-2 * time.Hour)
+	saved.Files[file.Path] = entry
+	if got := saved.LocalFile(file); got != file {
+		t.Errorf("LocalFile of an expired entry = %+v, want the repo's file", got)
+	}
+	if plan := (Planner{State: saved}).Plan(file, []string{path}); plan.Action != ActionDownload {
+		t.Errorf("plan with an expired entry = %s, want download", plan.Action)
+	}
+
+	// Entries from before timestamps count as expired; without a max age any entry is trusted
+	entry.RecordedAt = time.Time{}
+	saved.Files[file.Path] = entry
+	if got := saved.LocalFile(file); got != file {
+		t.Error("an untimestamped entry was trusted with a max age")
+	}
+	saved.SetMaxAge(0)
+	if got := saved.LocalFile(file); got == file {
+		t.Error("an untimestamped entry was ignored without a max age")
+	}
+}
+
+func TestNormalizeLineEndingsUnchanged(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "README.md", []byte("a\nb\n"))
+	changed, err := normalizeLineEndings(path, LineEndingsLF)
+	if err != nil || changed {
+		t.Fatalf("normalizeLineEndings = %v, %v; want false, nil", changed, err)
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		opts    globOptions
+		path    string
+		want    bool
+	}{
+		{"*.gguf", globOptions{foldCase: true, crossSlash: true}, "sub/Model-Q4_K_M.GGUF", true},
+		{"*Q4_K_M*", globOptions{foldCase: true, crossSlash: true}, "gguf/model-q4_k_m.gguf", true},
+		{"model-?.bin", globOptions{foldCase: true, crossSlash: true}, "model-1.bin", true},
+		{"model-[!0-9].bin", globOptions{foldCase: true, crossSlash: true}, "model-1.bin", false},
+		{"*.bin", globOptions{}, "sub/model.bin", false},
+		{"*.bin", globOptions{}, "model.BIN", false},
+		{"**/*.bin", globOptions{}, "model.bin", true},
+		{"**/*.bin", globOptions{}, "a/b/model.bin", true},
+		{"weights/**", globOptions{}, "weights/a/b.bin", true},
+		{"model-[12].bin", globOptions{}, "model-2.bin", true},
+	}
+	for _, tt := range tests {
+		re, err := compileGlob(tt.pattern, tt.opts)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("compileGlob(%q, %+v) matches %q = %v, want %v", tt.pattern, tt.opts, tt.path, got, tt.want)
+		}
+	}
+	if _, err := compileGlob("model-[12.bin", globOptions{}); err == nil {
+		t.Error("compileGlob accepted an unterminated class")
+	}
+}
+
+func TestMatchesLFS(t *testing.T) {
+	patterns := parseGitAttributes("*.bin filter=lfs diff=lfs merge=lfs -text\nsmall.bin -filter\nonnx/*.onnx filter=lfs\n")
+	for path, want := range map[string]bool{
+		"model.bin":       true,
+		"sub/model.bin":   true,
+		"small.bin":       false,
+		"onnx/model.onnx": true,
+		"model.onnx":      false,
+		"config.json":     false,
+	} {
+		if got := matchesLFS(patterns, path); got != want {
+			t.Errorf("matchesLFS(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestPlannerActions(t *testing.T) {
+	content := []byte(strings.Repeat("weights", 100))
+	file := File{Type: TypeFile, Path: "model.bin", Size: int64(len(content)), LFS: true, LFSOid: testOid(content, true)}
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "out", "model.bin")
+	reference := t.TempDir()
+	writeTestFile(t, reference, "model.bin", content)
+
+	plan := func(p Planner) FilePlan {
+		t.Helper()
+		return p.Plan(file, []string{outputPath})
+	}
+
+	if got := plan(Planner{}); got.Action != ActionDownload || got.Bytes != file.Size {
+		t.Errorf("missing file: %+v, want download of %d bytes", got, file.Size)
+	}
+	if got := plan(Planner{ExistingDirs: []string{reference}}); got.Action != ActionSkip || got.Existing == "" {
+		t.Errorf("copy in a reference directory: %+v, want skip", got)
+	}
+	if got := plan(Planner{ExistingDirs: []string{reference}, Hardlink: true}); got.Action != ActionLink {
+		t.Errorf("copy in a reference directory with Hardlink: %+v, want link", got)
+	}
+
+	// An interrupted download is resumed for the bytes it still lacks
+	writeTestFile(t, filepath.Dir(outputPath), "model.bin"+PartSuffix, content[:300])
+	if got := plan(Planner{}); got.Action != ActionResume || got.Bytes != file.Size-300 {
+		t.Errorf("partial download: %+v, want resume of %d bytes", got, file.Size-300)
+	}
+
+	// A complete copy is skipped by size, or by hash with DeepSync, unless forced
+	writeTestFile(t, filepath.Dir(outputPath), "model.bin", content)
+	os.Remove(outputPath + PartSuffix)
+	if got := plan(Planner{}); got.Action != ActionSkip {
+		t.Errorf("complete copy: %+v, want skip", got)
+	}
+	if got := plan(Planner{Force: true}); got.Action != ActionDownload {
+		t.Errorf("complete copy with Force: %+v, want download", got)
+	}
+	writeTestFile(t, filepath.Dir(outputPath), "model.bin", []byte(strings.Repeat("WEIGHTS", 100)))
+	if got := plan(Planner{}); got.Action != ActionSkip {
+		t.Errorf("same-size copy without DeepSync: %+v, want skip", got)
+	}
+	if got := plan(Planner{DeepSync: true}); got.Action != ActionDownload {
+		t.Errorf("changed copy with DeepSync: %+v, want download", got)
+	}
+}
+
+func TestChecksumCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.json", []byte(`{"a":1}`))
+	hasher := NewHasher(0, LoadChecksumCache(dir))
+
+	first, err := hasher.FileOid(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hasher.Cache().Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reloaded cache trusts the file while its size and modification time are unchanged
+	hasher = NewHasher(0, LoadChecksumCache(dir))
+	stat, _ := os.Stat(path)
+	if oid, ok := hasher.Cache().lookup(path, stat, false); !ok || oid != first {
+		t.Fatalf("lookup of an unchanged file = %q, %v; want %q", oid, ok, first)
+	}
+	if _, ok := hasher.Cache().lookup(path, stat, true); ok {
+		t.Error("an oid hashed as a git blob was returned for an LFS lookup")
+	}
+
+	// Same size, new modification time: the file is hashed again
+	modTime := stat.ModTime()
+	os.WriteFile(path, []byte(`{"a":2}`), 0644)
+	os.Chtimes(path, modTime.Add(time.Second), modTime.Add(time.Second))
+	stat, _ = os.Stat(path)
+	if _, ok := hasher.Cache().lookup(path, stat, false); ok {
+		t.Error("the cache trusted a file with a new modification time")
+	}
+	second, err := hasher.FileOid(path, false)
+	if err != nil || second == first || second != testOid([]byte(`{"a":2}`), false) {
+		t.Errorf("FileOid after a change = %q, %v; want the new content's oid", second, err)
+	}
+
+	// New size with the old modification time: hashed again as well
+	os.WriteFile(path, []byte(`{"a":22}`), 0644)
+	os.Chtimes(path, modTime.Add(time.Second), modTime.Add(time.Second))
+	if oid, _ := hasher.FileOid(path, false); oid != testOid([]byte(`{"a":22}`), false) {
+		t.Errorf("FileOid after a size change = %q, want the new content's oid", oid)
+	}
+}
+
+func TestChecksumCacheMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.json", []byte(`{"a":1}`))
+	cache := LoadChecksumCache(dir)
+	cache.SetMaxAge(time.Hour)
+	hasher := NewHasher(0, cache)
+	if _, err := hasher.FileOid(path, false); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(path)
+
+	// A fresh entry is trusted
+	if _, ok := cache.lookup(path, stat, false); !ok {
+		t.Error("a fresh entry was not trusted")
+	}
+
+	// An expired entry is hashed again, which renews it
+	key := cacheKey(path)
+	entry := cache.entries[key]
+	entry.HashedAt = time.Now().Add(-2 * time.Hour)
+	cache.entries[key] = entry
+	if _, ok := cache.lookup(path, stat, false); ok {
+		t.Error("an expired entry was trusted")
+	}
+	hits := cache.Hits()
+	if oid, err := hasher.FileOid(path, false); err != nil || oid != testOid([]byte(`{"a":1}`), false) {
+		t.Fatalf("FileOid of an expired entry = %q, %v", oid, err)
+	}
+	if cache.Hits() != hits {
+		t.Error("FileOid trusted an expired entry instead of hashing the file")
+	}
+	if _, ok := cache.lookup(path, stat, false); !ok {
+		t.Error("hashing the file again did not renew its entry")
+	}
+
+	// Without a max age, even an entry without a timestamp is trusted
+	entry.HashedAt = time.Time{}
+	cache.entries[key] = entry
+	cache.SetMaxAge(0)
+	if _, ok := cache.lookup(path, stat, false); !ok {
+		t.Error("an untimestamped entry was not trusted without a max age")
+	}
+}
+
+func TestHubRequestsStopOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Details(ctx, "org/m", "main"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Details = %v, want the context's deadline", err)
+	}
+	if _, err := client.Refs(ctx, "org/m"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Refs = %v, want the context's deadline", err)
+	}
+	files := []File{{Type: TypeFile, Path: "model.safetensors.index.json"}}
+	if _, err := client.ShardOrder(ctx, "org/m", "main", files); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ShardOrder = %v, want the context's deadline", err)
+	}
+	if _, err := client.ApplyGitAttributes(ctx, "org/m", "main", files); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ApplyGitAttributes = %v, want the context's deadline", err)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/models/org/m/revision/refs%2Fpr%2F3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id":"org/m","sha":"c0ffee","pipeline_tag":"text-generation","library_name":"transformers",
+			"downloads":12,"likes":3,"tags":["gguf","license:mit"],"gated":"manual","private":false,"cardData":{}}`))
+	}))
+	defer server.Close()
+	client := NewClient()
+	client.Endpoint = server.URL
+	client.Token = ""
+
+	details, err := client.Details(context.Background(), "org/m", "refs/pr/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ModelDetails{ID: "org/m", Sha: "c0ffee", PipelineTag: "text-generation", Library: "transformers", License: "mit",
+		Downloads: 12, Likes: 3, Tags: []string{"gguf", "license:mit"}, Gated: "manual"}
+	if fmt.Sprint(details) != fmt.Sprint(want) {
+		t.Errorf("Details = %+v, want %+v", details, want)
+	}
+	if _, err := client.Details(context.Background(), "org/missing", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Details of a missing model = %v, want a 404 error", err)
+	}
+}
+
+func TestFindExisting(t *testing.T) {
+	content := []byte(strings.Repeat("weights", 100))
+	file := File{Type: TypeFile, Path: "onnx/model.bin", Size: int64(len(content)), LFS: true, LFSOid: testOid(content, true)}
+
+	// A copy is found at its repo path or by file name, but only with the same size and oid
+	byPath, byName, wrongSize, wrongOid := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+	writeTestFile(t, byPath, "onnx/model.bin", content)
+	writeTestFile(t, byName, "model.bin", content)
+	writeTestFile(t, wrongSize, "model.bin", content[:len(content)-1])
+	writeTestFile(t, wrongOid, "onnx/model.bin", []byte(strings.Repeat("WEIGHTS", 100)))
+	tests := []struct {
+		dirs []string
+		want string
+	}{
+		{[]string{byPath}, filepath.Join(byPath, "onnx", "model.bin")},
+		{[]string{byName}, filepath.Join(byName, "model.bin")},
+		{[]string{wrongSize}, ""},
+		{[]string{wrongOid}, ""},
+		{[]string{wrongOid, wrongSize, byName}, filepath.Join(byName, "model.bin")},
+	}
+	for _, tt := range tests {
+		if got := findExisting(tt.dirs, file, nil); got != tt.want {
+			t.Errorf("findExisting(%v) = %q, want %q", tt.dirs, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadAllHardlinksExisting(t *testing.T) {
+	content := []byte(strings.Repeat("weights", 100))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content, "config.json": []byte(`{}`)}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	reference := t.TempDir()
+	existing := writeTestFile(t, reference, "model.bin", content)
+
+	dest := t.TempDir()
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{
+		Model:   "org/m",
+		Dest:    dest,
+		Planner: Planner{ExistingDirs: []string{reference}, Hardlink: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range report.Files {
+		want, action := StatusDownloaded, ActionDownload
+		if result.File.Path == "model.bin" {
+			want, action = StatusSkipped, ActionLink
+		}
+		if result.Status != want || result.Plan.Action != action {
+			t.Errorf("%s: %s (%s), want %s (%s)", result.File.Path, result.Status, result.Plan.Action, want, action)
+		}
+	}
+	for _, request := range repo.requests {
+		if strings.Contains(request, "model.bin") {
+			t.Errorf("the linked file was requested: %s", request)
+		}
+	}
+	src, _ := os.Stat(existing)
+	linked, err := os.Stat(filepath.Join(dest, "model.bin"))
+	if err != nil || !os.SameFile(src, linked) {
+		t.Errorf("model.bin is not a hardlink to the reference copy (%v)", err)
+	}
+}
+
+// repoPaths returns the repo paths of files in order
+func repoPaths(files []File) []string {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	return paths
+}
+
+func TestSelectionMaxFiles(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "c.bin", Size: 30},
+		{Type: TypeFile, Path: "a.bin", Size: 20},
+		{Type: TypeFile, Path: "d.json", Size: 10},
+		{Type: TypeFile, Path: "b.bin", Size: 20},
+	}
+	tests := []struct {
+		order    string
+		maxFiles int
+		want     []string
+	}{
+		{"", 2, []string{"a.bin", "b.bin"}},
+		{OrderSize, 2, []string{"d.json", "a.bin"}},
+		{OrderSizeDesc, 3, []string{"c.bin", "a.bin", "b.bin"}},
+		{OrderPath, 10, []string{"a.bin", "b.bin", "c.bin", "d.json"}},
+	}
+	for _, tt := range tests {
+		var excluded []string
+		why := NewExplainer(func(file File, decision string, reasons []string) { excluded = append(excluded, file.Path) })
+		result, err := Selection{Order: tt.order, MaxFiles: tt.maxFiles}.Apply(files, why)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := repoPaths(result.Files); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("order %q, max %d: kept %v, want %v", tt.order, tt.maxFiles, got, tt.want)
+		}
+		step, ran := result.Step(StepMaxFiles)
+		if cut := len(files) > tt.maxFiles; ran != cut || len(excluded) != len(files)-len(tt.want) || (cut && step.After != tt.maxFiles) {
+			t.Errorf("order %q, max %d: step %+v (ran %v), excluded %v", tt.order, tt.maxFiles, step, ran, excluded)
+		}
+	}
+	if files[0].Path != "c.bin" {
+		t.Error("Apply reordered its input")
+	}
+}
+
+func TestDownloadAllLayouts(t *testing.T) {
+	repo := &testRepo{
+		files: map[string][]byte{"config.json": []byte(`{"a":1}`), "sub/tok.json": []byte(`{"t":2}`), "model.bin": []byte("weights")},
+		lfs:   map[string]bool{"model.bin": true},
+	}
+	client := newTestClient(t, repo)
+	out := t.TempDir()
+
+	// Every layout receives the same download, each under its own directory
+	var layouts []Layout
+	for _, format := range []string{LayoutNested, LayoutFlat, LayoutHub} {
+		layout, err := NewLayout(format, filepath.Join(out, format), "org/m", "main", "c0ffee")
+		if err != nil {
+			t.Fatal(err)
+		}
+		layouts = append(layouts, layout)
+	}
+	if _, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Layouts: layouts}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"nested/org_m/config.json":                          `{"a":1}`,
+		"nested/org_m/sub/tok.json":                         `{"t":2}`,
+		"flat/org_m/config.json":                            `{"a":1}`,
+		"flat/org_m/tok.json":                               `{"t":2}`,
+		"hub/models--org--m/snapshots/c0ffee/sub/tok.json":  `{"t":2}`,
+		"hub/models--org--m/snapshots/c0ffee/model.bin":     "weights",
+		"hub/models--org--m/blobs/" + repo.oid("model.bin"): "weights",
+		"hub/models--org--m/refs/main":                      "c0ffee",
+	}
+	for path, content := range want {
+		if got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(path))); err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "flat", "org_m", "sub")); !os.IsNotExist(err) {
+		t.Errorf("the flat layout created a subdirectory (%v)", err)
+	}
+	if _, err := NewLayout(LayoutHub, out, "org/m", "main", ""); err == nil {
+		t.Error("NewLayout accepted the hub layout without a commit")
+	}
+	if _, err := NewLayout("tree", out, "org/m", "main", "c0ffee"); err == nil {
+		t.Error("NewLayout accepted an unknown format")
+	}
+}
+
+func TestDownloadAllRecordsServerHashes(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "model.bin": []byte("weights")}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	dest := t.TempDir()
+
+	// X-Repo-Commit and the ETag of each file end up in the state sidecar
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Commit != "c0ffee" {
+		t.Errorf("report commit = %q, want c0ffee", report.Commit)
+	}
+	state := LoadState(dest, "org/m", "main")
+	for _, path := range []string{"config.json", "model.bin"} {
+		entry := state.Files[path]
+		if entry.Commit != "c0ffee" || entry.ETag != repo.oid(path) || entry.Oid != repo.oid(path) {
+			t.Errorf("state of %s = %+v, want commit c0ffee and ETag %s", path, entry, repo.oid(path))
+		}
+	}
+
+	// An ETag that disagrees with the listing means the file changed in between
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		w.Header().Set("ETag", `"`+strings.Repeat("f", 40)+`"`)
+		w.Write(repo.files[path])
+		return true
+	}
+	_, err = client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), Filter: func(f File) bool { return !f.LFS }})
+	if err == nil || !strings.Contains(err.Error(), "remote file changed") {
+		t.Errorf("DownloadAll with a changed ETag = %v, want a remote change error", err)
+	}
+}
+
+func TestVerifyLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, path := range []string{"good.json", "sub/good.bin", "corrupt.bin", "short.json", "missing.json"} {
+		content := []byte(strings.Repeat(path, 20))
+		lfs := strings.HasSuffix(path, ".bin")
+		file := File{Type: TypeFile, Path: path, Size: int64(len(content)), LFS: lfs}
+		if lfs {
+			file.LFSOid = testOid(content, true)
+		} else {
+			file.Oid = testOid(content, false)
+		}
+		files = append(files, file)
+
+		switch path {
+		case "corrupt.bin":
+			content = []byte(strings.Repeat(strings.ToUpper(path), 20))
+		case "short.json":
+			content = content[1:]
+		case "missing.json":
+			continue
+		}
+		writeTestFile(t, dir, path, content)
+	}
+
+	want := map[string]string{"good.json": "", "sub/good.bin": "", "corrupt.bin": "checksum mismatch", "short.json": "size mismatch", "missing.json": "missing"}
+	results := VerifyLocalFiles(dir, files, 3, NewHasher(2, nil))
+	for i, result := range results {
+		if result.File.Path != files[i].Path {
+			t.Fatalf("result %d is for %s, want %s", i, result.File.Path, files[i].Path)
+		}
+		if msg := want[result.File.Path]; (msg == "") != (result.Err == nil) || (msg != "" && !strings.Contains(result.Err.Error(), msg)) {
+			t.Errorf("%s: %v, want %q", result.File.Path, result.Err, msg)
+		}
+	}
+}
+
+func TestSelectionRegexp(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "config.json"},
+		{Type: TypeFile, Path: "model-00001-of-00002.safetensors"},
+		{Type: TypeFile, Path: "model-00002-of-00002.safetensors"},
+		{Type: TypeFile, Path: "onnx/model.onnx"},
+	}
+	reasons := map[string][]string{}
+	why := NewExplainer(func(file File, decision string, r []string) { reasons[file.Path] = r })
+	result, err := Selection{Regexp: regexp.MustCompile(`^model-\d+-of-\d+\.safetensors$|\.json$`)}.Apply(files, why)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"config.json", "model-00001-of-00002.safetensors", "model-00002-of-00002.safetensors"}
+	if got := repoPaths(result.Files); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if step, _ := result.Step(StepRegexp); step.Before != 4 || step.After != 3 {
+		t.Errorf("regexp step = %+v, want 4 to 3 files", step)
+	}
+	if r := reasons["onnx/model.onnx"]; len(r) != 1 || !strings.Contains(r[0], "does not match") {
+		t.Errorf("reasons for the excluded file = %q", r)
+	}
+}
+
+func TestDownloadAllCacheDir(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 300))
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "README.md": []byte("hi"), "model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "README.md" {
+			return false
+		}
+		w.Write([]byte("ho"))
+		return true
+	}
+	client := newTestClient(t, repo)
+	out, cache := t.TempDir(), t.TempDir()
+	layout, err := NewLayout(LayoutNested, out, "org/m", "main", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout.CacheDir = filepath.Join(cache, "org_m")
+
+	// Partial downloads are kept in the cache directory, apart for each output directory
+	part, err := layout.PartPath(repo.file("model.bin"))
+	if err != nil || !strings.HasPrefix(part, layout.CacheDir) {
+		t.Fatalf("PartPath = %q, %v; want a path in the cache directory", part, err)
+	}
+	other, _ := NewLayout(LayoutNested, t.TempDir(), "org/m", "main", "")
+	other.CacheDir = layout.CacheDir
+	if otherPart, _ := other.PartPath(repo.file("model.bin")); otherPart == part {
+		t.Errorf("two output directories share the part file %s", part)
+	}
+	os.MkdirAll(filepath.Dir(part), 0755)
+	os.WriteFile(part, content[:1000], 0644)
+	plans, err := (Planner{}).PlanFiles([]File{repo.file("model.bin")}, []Layout{layout})
+	if err != nil || plans[0].Action != ActionResume {
+		t.Fatalf("PlanFiles = %+v, %v; want model.bin resumed", plans, err)
+	}
+
+	// The state, the quarantined download and the resumed part go to the cache directory
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Layouts: []Layout{layout}, Quarantine: true})
+	if err == nil || len(report.Quarantined) != 1 {
+		t.Fatalf("DownloadAll = %v, quarantined %v; want README.md quarantined", err, report.Quarantined)
+	}
+	if !strings.HasPrefix(report.Quarantined[0], layout.CacheDir) {
+		t.Errorf("quarantined to %s, outside the cache directory", report.Quarantined[0])
+	}
+	if state := LoadState(layout.CacheDir, "org/m", "main"); state.Files["config.json"].Commit != "c0ffee" {
+		t.Errorf("no state recorded in the cache directory: %+v", state)
+	}
+	if got, err := os.ReadFile(filepath.Join(layout.ModelDir, "model.bin")); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("resumed model.bin = %d bytes, %v; want the whole file", len(got), err)
+	}
+	if !slices.ContainsFunc(repo.requests, func(r string) bool { return strings.HasSuffix(r, "bytes=1000-") }) {
+		t.Errorf("requests = %q, want model.bin resumed from the cached part", repo.requests)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Errorf("the part file was left in the cache directory (%v)", err)
+	}
+	filepath.WalkDir(out, func(path string, d os.DirEntry, err error) error {
+		if name := filepath.Base(path); err == nil && !d.IsDir() && name != "config.json" && name != "model.bin" {
+			t.Errorf("%s was written to the output directory", path)
+		}
+		return nil
+	})
+}
+
+func TestListTreeReportsPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?recursive=true&cursor=2>; rel="next"`, server.URL, r.URL.Path))
+			fmt.Fprint(w, `[{"type":"file","path":"a.json","size":2,"oid":"a"},{"type":"file","path":"b.json","size":2,"oid":"b"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"type":"file","path":"c.json","size":2,"oid":"c"}]`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	var pages []int
+	files, err := client.ListTree(context.Background(), "org/m", "main", TreeOptions{OnPage: func(n int) { pages = append(pages, n) }})
+	if err != nil || len(files) != 3 {
+		t.Fatalf("ListTree = %v, %v; want 3 files", files, err)
+	}
+	if fmt.Sprint(pages) != "[2 1]" {
+		t.Errorf("OnPage saw %v, want [2 1]", pages)
+	}
+}
+
+func TestSelectionLFS(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "config.json"},
+		{Type: TypeFile, Path: "model.safetensors", LFS: true},
+		{Type: TypeFile, Path: "tokenizer.json"},
+	}
+	tests := []struct {
+		selection Selection
+		want      []string
+	}{
+		{Selection{SkipLFS: true}, []string{"config.json", "tokenizer.json"}},
+		{Selection{OnlyLFS: true}, []string{"model.safetensors"}},
+		{Selection{}, []string{"config.json", "model.safetensors", "tokenizer.json"}},
+	}
+	for _, tt := range tests {
+		result, err := tt.selection.Apply(files, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := repoPaths(result.Files); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%+v kept %v, want %v", tt.selection, got, tt.want)
+		}
+		if _, ran := result.Step(StepLFS); ran != (tt.selection.SkipLFS || tt.selection.OnlyLFS) {
+			t.Errorf("%+v: LFS step ran = %v", tt.selection, ran)
+		}
+	}
+}
+
+func TestDownloadFileMirrors(t *testing.T) {
+	content := []byte(strings.Repeat("weights", 100))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	file := repo.file("model.bin")
+
+	// Every mirror receives the content from a single request
+	dirs := []string{t.TempDir(), t.TempDir()}
+	paths := []string{filepath.Join(dirs[0], "model.bin"), filepath.Join(dirs[1], "m", "model.bin")}
+	if _, err := client.DownloadFile(context.Background(), "org/m", "main", file, paths, FileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if got, _ := os.ReadFile(path); string(got) != string(content) {
+			t.Errorf("%s does not hold the file", path)
+		}
+	}
+	if len(repo.requests) != 1 {
+		t.Errorf("requests = %q, want one for both mirrors", repo.requests)
+	}
+
+	// A mirror that cannot be written is named without failing the others
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	os.WriteFile(blocked, nil, 0644)
+	paths = []string{filepath.Join(t.TempDir(), "model.bin"), filepath.Join(blocked, "model.bin")}
+	_, err := client.DownloadFile(context.Background(), "org/m", "main", file, paths, FileOptions{})
+	var mirrorErr *MirrorError
+	if !errors.As(err, &mirrorErr) || mirrorErr.Failed(paths[0]) != nil || mirrorErr.Failed(paths[1]) == nil {
+		t.Fatalf("DownloadFile = %v, want a MirrorError for %s only", err, paths[1])
+	}
+	if got, _ := os.ReadFile(paths[0]); string(got) != string(content) {
+		t.Error("the writable mirror does not hold the file")
+	}
+}
+
+// fullDisk accepts room bytes, then fails writes with ENOSPC until it has failed
+// freeAfter times, when space frees up
+type fullDisk struct {
+	data      []byte
+	room      int
+	freeAfter int
+}
+
+func (d *fullDisk) Write(p []byte) (int, error) {
+	if d.freeAfter == 0 {
+		d.room = len(p)
+	}
+	n := min(d.room, len(p))
+	d.data = append(d.data, p[:n]...)
+	d.room -= n
+	if n < len(p) {
+		d.freeAfter--
+		return n, &os.PathError{Op: "write", Path: "model.bin.part", Err: syscall.ENOSPC}
+	}
+	return n, nil
+}
+
+func TestDiskFullWriter(t *testing.T) {
+	// Writes continue where they stopped once space frees up
+	disk := &fullDisk{room: 3, freeAfter: 2}
+	var events []Event
+	w := &diskFullWriter{ctx: context.Background(), w: disk, timeout: time.Minute, poll: time.Millisecond, notify: func(e Event) { events = append(events, e) }}
+	if n, err := w.Write([]byte("weights")); err != nil || n != 7 {
+		t.Fatalf("Write = %d, %v; want 7 bytes", n, err)
+	}
+	if string(disk.data) != "weights" {
+		t.Errorf("wrote %q, want %q", disk.data, "weights")
+	}
+	if len(events) != 1 || events[0].Kind != EventDiskFull {
+		t.Errorf("events = %+v, want one EventDiskFull", events)
+	}
+
+	// A disk that stays full fails the write once the wait is over
+	w = &diskFullWriter{ctx: context.Background(), w: writerErr{syscall.ENOSPC}, timeout: 5 * time.Millisecond, poll: time.Millisecond, notify: func(Event) {}}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, syscall.ENOSPC) || !strings.Contains(err.Error(), "still full") {
+		t.Errorf("Write to a full disk = %v, want ENOSPC after the wait", err)
+	}
+	// Other errors are not retried
+	w.w = writerErr{syscall.EIO}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, syscall.EIO) {
+		t.Errorf("Write = %v, want EIO", err)
+	}
+
+	// Cancelling the download ends the wait at once, keeping what was written
+	ctx, cancel := context.WithCancel(context.Background())
+	disk = &fullDisk{room: 3, freeAfter: 1 << 30}
+	w = &diskFullWriter{ctx: ctx, w: disk, timeout: time.Hour, poll: time.Hour, notify: func(Event) { cancel() }}
+	start := time.Now()
+	if n, err := w.Write([]byte("weights")); n != 3 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Write after cancelling = %d, %v; want 3 bytes and context.Canceled", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled Write took %s", elapsed)
+	}
+}
+
+// writerErr fails every write with its error
+type writerErr struct{ err error }
+
+func (w writerErr) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestRefs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/models/org/m/refs" || r.URL.Query().Get("include_prs") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"branches":[{"name":"main","ref":"refs/heads/main","targetCommit":"c0ffee"}],
+			"tags":[{"name":"v1","ref":"refs/tags/v1","targetCommit":"beef"}],"converts":[],
+			"pullRequests":[{"name":"3","ref":"refs/pr/3","targetCommit":"f00d"}]}`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	refs, err := client.Refs(context.Background(), "org/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ModelRefs{
+		Branches:     []GitRef{{Name: "main", Ref: "refs/heads/main", TargetCommit: "c0ffee"}},
+		Tags:         []GitRef{{Name: "v1", Ref: "refs/tags/v1", TargetCommit: "beef"}},
+		Converts:     []GitRef{},
+		PullRequests: []GitRef{{Name: "3", Ref: "refs/pr/3", TargetCommit: "f00d"}},
+	}
+	if fmt.Sprint(refs) != fmt.Sprint(want) {
+		t.Errorf("Refs = %+v, want %+v", refs, want)
+	}
+	if _, err := client.Refs(context.Background(), "org/missing"); err == nil {
+		t.Error("Refs of a missing model succeeded")
+	}
+}
+
+func TestSortFilesDeterministic(t *testing.T) {
+	files := []File{
+		{Path: "b.bin", Size: 5},
+		{Path: "a.json", Size: 1},
+		{Path: "c.bin", Size: 5},
+		{Path: "sub/a.bin", Size: 9},
+		{Path: "a.bin", Size: 5},
+	}
+	want := map[string][]string{
+		OrderPath:     {"a.bin", "a.json", "b.bin", "c.bin", "sub/a.bin"},
+		OrderSize:     {"a.json", "a.bin", "b.bin", "c.bin", "sub/a.bin"},
+		OrderSizeDesc: {"sub/a.bin", "a.bin", "b.bin", "c.bin", "a.json"},
+	}
+	// Any listing order gives the same result; equal sizes fall back to the path
+	for order, paths := range want {
+		for shift := range files {
+			shuffled := append(append([]File(nil), files[shift:]...), files[:shift]...)
+			if err := SortFiles(shuffled, order, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := repoPaths(shuffled); fmt.Sprint(got) != fmt.Sprint(paths) {
+				t.Errorf("%s order from rotation %d = %v, want %v", order, shift, got, paths)
+			}
+		}
+	}
+	if err := SortFiles(files, "mtime", nil); err == nil {
+		t.Error("SortFiles accepted an unknown order")
+	}
+}
+
+func TestExplainer(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{}`), "README.md": []byte("hi"), "model.bin": []byte("weights")}}
+	client := newTestClient(t, repo)
+	dest := t.TempDir()
+	writeTestFile(t, dest, "README.md", []byte("hi"))
+	writeTestFile(t, dest, "config.json", []byte("{"))
+
+	type decision struct {
+		action  string
+		reasons []string
+	}
+	var mu sync.Mutex
+	got := map[string]decision{}
+	why := NewExplainer(func(file File, action string, reasons []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[file.Path] = decision{action, reasons}
+	})
+	files, err := client.ListFiles(context.Background(), "org/m", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Selection{Regexp: regexp.MustCompile(`\.(json|md)$`)}.Apply(files, why)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest, Files: result.Files, Planner: Planner{Explain: why}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each file gets one decision, with the reasons from selection and planning in order
+	want := map[string]decision{
+		"model.bin":   {"excluded", []string{`path does not match \.(json|md)$`}},
+		"README.md":   {ActionSkip, []string{`matched \.(json|md)$`, "already present"}},
+		"config.json": {ActionDownload, []string{`matched \.(json|md)$`, "local copy is incomplete: size mismatch: expected 2 bytes, found 1", "selected for download"}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("decisions = %v, want %v", got, want)
+	}
+}
+
+func TestHasherBound(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.json", []byte(`{"a":1}`))
+	hasher := NewHasher(1, LoadChecksumCache(dir))
+
+	// With the only slot taken, hashing waits for it
+	hasher.slots <- struct{}{}
+	done := make(chan string)
+	go func() {
+		oid, _ := hasher.FileOid(path, false)
+		done <- oid
+	}()
+	select {
+	case <-done:
+		t.Fatal("FileOid hashed while every slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-hasher.slots
+	select {
+	case oid := <-done:
+		if oid != testOid([]byte(`{"a":1}`), false) {
+			t.Errorf("FileOid = %q", oid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("FileOid did not run once a slot was free")
+	}
+
+	// A cached file needs no slot, and 0 threads means no bound
+	hasher.slots <- struct{}{}
+	if _, err := hasher.FileOid(path, false); err != nil || hasher.Cache().Hits() != 1 {
+		t.Errorf("cached FileOid = %v with %d hits, want a cache hit", err, hasher.Cache().Hits())
+	}
+	if NewHasher(0, nil).slots != nil {
+		t.Error("NewHasher(0) bounds hashing")
+	}
+}
+
+func TestListTreeCache(t *testing.T) {
+	var requests []string
+	etag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `[{"type":"file","path":"config.json","size":2,"oid":%q}]`, etag)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	cachePath := filepath.Join(t.TempDir(), TreeCacheName("refs/pr/3"))
+	list := func() string {
+		t.Helper()
+		files, err := client.ListTree(context.Background(), "org/m", "refs/pr/3", TreeOptions{CachePath: cachePath})
+		if err != nil || len(files) != 1 {
+			t.Fatalf("ListTree = %v, %v; want one file", files, err)
+		}
+		return files[0].Oid
+	}
+
+	// The first listing is cached, the second revalidated and served from the cache
+	if oid := list(); oid != `"v1"` {
+		t.Errorf("first listing oid = %s", oid)
+	}
+	if oid := list(); oid != `"v1"` {
+		t.Errorf("cached listing oid = %s", oid)
+	}
+	// A changed listing replaces the cache
+	etag = `"v2"`
+	if oid := list(); oid != `"v2"` {
+		t.Errorf("changed listing oid = %s, want the new listing", oid)
+	}
+	if want := []string{"", `"v1"`, `"v1"`}; fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("If-None-Match headers = %q, want %q", requests, want)
+	}
+	if filepath.Base(cachePath) != ".hugdl-tree-refs_pr_3.json" {
+		t.Errorf("TreeCacheName keeps a slash: %s", filepath.Base(cachePath))
+	}
+}
+
+func TestPathTransformTemplate(t *testing.T) {
+	tests := []struct {
+		transform PathTransform
+		repoPath  string
+		want      string
+	}{
+		{PathTransform{Template: "split=train/{path}"}, "data/part-0.parquet", "split=train/data/part-0.parquet"},
+		{PathTransform{Template: "{dir}/lang=en/{name}"}, "data/part-0.parquet", "data/lang=en/part-0.parquet"},
+		{PathTransform{Template: "{dir}/lang=en/{name}"}, "part-0.parquet", "lang=en/part-0.parquet"},
+		{PathTransform{StripPrefix: "data/", Template: "year=2024/{path}"}, "data/jan/part-0.parquet", "year=2024/jan/part-0.parquet"},
+		{PathTransform{Template: "../{name}"}, "data/part-0.parquet", ""},
+		{PathTransform{Template: "{dir}"}, "part-0.parquet", ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.transform.apply(tt.repoPath)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%+v accepted %s as %q", tt.transform, tt.repoPath, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%+v maps %s to %q, %v; want %q", tt.transform, tt.repoPath, got, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadAllDeepSync(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "model.bin": []byte("weights")}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	dest := t.TempDir()
+	if _, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest}); err != nil {
+		t.Fatal(err)
+	}
+	// An edit that keeps the size is only noticed by hashing
+	writeTestFile(t, dest, "model.bin", []byte("WEIGHTS"))
+
+	for _, deep := range []bool{false, true} {
+		report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest, Planner: Planner{DeepSync: deep}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range report.Files {
+			want := StatusSkipped
+			if deep && result.File.Path == "model.bin" {
+				want = StatusDownloaded
+			}
+			if result.Status != want {
+				t.Errorf("DeepSync=%v: %s %s, want %s", deep, result.File.Path, result.Status, want)
+			}
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "model.bin")); string(got) != "weights" {
+		t.Errorf("model.bin = %q after the deep sync, want the repo's content", got)
+	}
+}
+
+func TestChooseQuant(t *testing.T) {
+	const gb = 1 << 30
+	files := []File{
+		{Path: "model-Q2_K.gguf", Size: 3 * gb},
+		{Path: "model-Q4_K_M-00001-of-00002.gguf", Size: 3 * gb},
+		{Path: "model-Q4_K_M-00002-of-00002.gguf", Size: 2 * gb},
+		{Path: "model-Q8_0.gguf", Size: 9 * gb},
+		{Path: "config.json", Size: 100},
+	}
+	tests := []struct {
+		budget int64
+		label  string
+		fits   bool
+		files  int
+	}{
+		{16 * gb, "Q8_0", true, 1},
+		{8 * gb, "Q4_K_M", true, 2}, // both shards of the quant that fits
+		{4 * gb, "Q2_K", true, 1},
+		{1 * gb, "Q2_K", false, 1}, // nothing fits: the smallest
+	}
+	for _, tt := range tests {
+		choice, ok := ChooseQuant(files, tt.budget)
+		if !ok || choice.Label != tt.label || choice.Fits != tt.fits || len(choice.Files) != tt.files {
+			t.Errorf("ChooseQuant(%d GB) = %+v, want %s (fits %v) with %d files", tt.budget/gb, choice, tt.label, tt.fits, tt.files)
+		}
+	}
+	if _, ok := ChooseQuant(files[4:], 16*gb); ok {
+		t.Error("ChooseQuant found a quant without GGUF files")
+	}
+}
+
+func TestDownloadAllQuarantine(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "README.md": []byte("hi"), "vocab.txt": []byte("a\nb\n")}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "config.json" {
+			return false
+		}
+		w.Write([]byte(`{"a":2}`))
+		return true
+	}
+	client := newTestClient(t, repo)
+
+	// The corrupt file is kept for inspection and the run continues with the others
+	dest := t.TempDir()
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest, Concurrency: 1, Quarantine: true})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || report.Count(StatusDownloaded) != 2 || report.Count(StatusFailed) != 1 {
+		t.Fatalf("DownloadAll = %v with %+v, want one checksum failure and two downloads", err, report.Files)
+	}
+	want := filepath.Join(dest, "quarantine", "config.json")
+	if len(report.Quarantined) != 1 || report.Quarantined[0] != want {
+		t.Errorf("quarantined %v, want %s", report.Quarantined, want)
+	}
+	if got, _ := os.ReadFile(want); string(got) != `{"a":2}` {
+		t.Errorf("quarantined copy = %q, want the corrupt download", got)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "config.json")); !os.IsNotExist(err) {
+		t.Errorf("the corrupt file was left in place (%v)", err)
+	}
+
+	// Without quarantine the corrupt download is simply discarded
+	dest = t.TempDir()
+	report, _ = client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: dest})
+	if _, err := os.Stat(filepath.Join(dest, "quarantine")); len(report.Quarantined) != 0 || !os.IsNotExist(err) {
+		t.Errorf("quarantined %v without Quarantine (%v)", report.Quarantined, err)
+	}
+}
+
+func TestDownloadAllQuarantineEveryLayout(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`)}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		w.Write([]byte(`{"a":2}`))
+		return true
+	}
+	client := newTestClient(t, repo)
+	var layouts []Layout
+	for range 3 {
+		layout, _ := NewLayout(LayoutNested, t.TempDir(), "org/m", "main", "")
+		layouts = append(layouts, layout)
+	}
+	// The first layout's quarantine folder cannot be created
+	blocked := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocked, nil, 0644)
+	layouts[0].CacheDir = blocked
+
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Layouts: layouts, Quarantine: true})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || !strings.Contains(err.Error(), "could not quarantine "+filepath.Join(layouts[0].ModelDir, "config.json")) {
+		t.Errorf("DownloadAll = %v, want the checksum error and the failed quarantine", err)
+	}
+	// The other layouts still quarantine their copies, and no corrupt copy stays in place
+	if len(report.Quarantined) != 2 {
+		t.Errorf("quarantined %v, want the copies of the other two layouts", report.Quarantined)
+	}
+	for _, layout := range layouts {
+		if _, err := os.Stat(filepath.Join(layout.ModelDir, "config.json")); !os.IsNotExist(err) {
+			t.Errorf("the corrupt copy in %s was left in place (%v)", layout.ModelDir, err)
+		}
+	}
+}
+
+func TestDownloadAllIndex(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"sub/config.json": []byte(`{"a":1}`), "model.bin": []byte("weights"), "index.json": []byte(`{"repo":true}`), "README.md": []byte("hi")}, lfs: map[string]bool{"model.bin": true}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "README.md" {
+			return false
+		}
+		http.Error(w, "gone", http.StatusNotFound)
+		return true
+	}
+	client := newTestClient(t, repo)
+	out := t.TempDir()
+	nested, _ := NewLayout(LayoutNested, out, "org/m", "main", "c0ffee")
+	hub, _ := NewLayout(LayoutHub, out, "org/m", "main", "c0ffee")
+	client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Layouts: []Layout{nested, hub}, WriteIndex: true})
+
+	// Only files that made it to disk are listed, with paths relative to the model
+	for dir, local := range map[string]string{nested.ModelDir: "model.bin", hub.RepoDir: "model.bin"} {
+		data, err := os.ReadFile(filepath.Join(dir, IndexFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index modelIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			t.Fatal(err)
+		}
+		if index.Model != "org/m" || index.Revision != "main" || index.Commit != "c0ffee" || len(index.Files) != 3 {
+			t.Fatalf("index in %s = %+v, want the three downloaded files", dir, index)
+		}
+		entries := map[string]indexEntry{}
+		for _, entry := range index.Files {
+			entries[entry.Path] = entry
+		}
+		bin := entries["model.bin"]
+		if bin.Local != local || bin.SHA256 != repo.oid("model.bin") || bin.Size != 7 || !strings.HasSuffix(bin.URL, "/org/m/resolve/main/model.bin") || bin.Commit != "c0ffee" {
+			t.Errorf("model.bin entry in %s = %+v", dir, bin)
+		}
+		if config := entries["sub/config.json"]; config.Oid != repo.oid("sub/config.json") || config.SHA256 != "" {
+			t.Errorf("sub/config.json entry in %s = %+v, want its git oid and no SHA256", dir, config)
+		}
+	}
+
+	// The repo's own index.json is downloaded like any other file and left alone by the sidecar
+	for _, path := range []string{filepath.Join(nested.ModelDir, "index.json"), filepath.Join(hub.ModelDir, "index.json")} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != `{"repo":true}` {
+			t.Errorf("%s = %q, %v; want the repo's file", path, got, err)
+		}
+	}
+}
+
+func TestStripComponents(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "README.md"},
+		{Type: TypeFile, Path: "data/train.parquet"},
+		{Type: TypeFile, Path: "data/en/test.parquet"},
+	}
+	tests := []struct {
+		n    int
+		want map[string]string // repo path to local path; files left out are dropped
+	}{
+		{1, map[string]string{"data/train.parquet": "train.parquet", "data/en/test.parquet": "en/test.parquet"}},
+		{2, map[string]string{"data/en/test.parquet": "test.parquet"}},
+	}
+	for _, tt := range tests {
+		transform := PathTransform{StripComponents: tt.n}
+		result, err := Selection{Transform: transform}.Apply(files, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step, _ := result.Step(StepStrip); step.Before != 3 || step.After != len(tt.want) {
+			t.Errorf("N=%d: strip step %+v, want %d files kept", tt.n, step, len(tt.want))
+		}
+		layout := Layout{Format: LayoutNested, ModelDir: "out", Transform: transform}
+		for _, file := range result.Files {
+			got, err := layout.FilePath(file)
+			if want, ok := tt.want[file.Path]; !ok || err != nil || got != filepath.Join("out", filepath.FromSlash(want)) {
+				t.Errorf("N=%d: %s goes to %q, %v; want out/%s", tt.n, file.Path, got, err, want)
+			}
+		}
+		if _, err := layout.FilePath(files[0]); err == nil {
+			t.Errorf("N=%d: a file with no path left was given one", tt.n)
+		}
+	}
+}
+
+// serveDocuments serves each path's content, answering 404 for anything else
+func serveDocuments(t *testing.T, docs map[string][]byte) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := docs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+}
+
+func TestManifest(t *testing.T) {
+	lfsOid := strings.Repeat("ab", 32)
+	client := serveDocuments(t, map[string][]byte{
+		"/files.json":  []byte(`[{"path":"config.json","size":7,"oid":"0123abcd"},{"path":"model.bin","size":9,"oid":"` + lfsOid + `"}]`),
+		"/nopath.json": []byte(`[{"size":7,"oid":"0123abcd"}]`),
+		"/bad.json":    []byte(`{"files":[]}`),
+	})
+
+	files, err := client.Manifest(context.Background(), client.Endpoint+"/files.json", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{Type: TypeFile, Path: "config.json", Size: 7, Oid: "0123abcd"},
+		{Type: TypeFile, Path: "model.bin", Size: 9, LFS: true, LFSOid: lfsOid},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("Manifest = %+v, want %+v", files, want)
+	}
+	for _, path := range []string{"/nopath.json", "/bad.json", "/missing.json"} {
+		if _, err := client.Manifest(context.Background(), client.Endpoint+path, nil, ""); err == nil {
+			t.Errorf("Manifest accepted %s", path)
+		}
+	}
+}
+
+func TestDownloadAllAbortOnChecksumMismatch(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"a.json": []byte(`{"a":1}`), "b.json": []byte("[]"), "c.json": []byte(`""`)}}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		if path != "a.json" {
+			return false
+		}
+		w.Write([]byte(`{"a":2}`))
+		return true
+	}
+	client := newTestClient(t, repo)
+	files := []File{repo.file("a.json"), repo.file("b.json"), repo.file("c.json")}
+
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), Files: files, Concurrency: 1, AbortOnChecksumMismatch: true})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || report.Aborted != "a.json" {
+		t.Fatalf("DownloadAll = %v, aborted at %q; want the run stopped at a.json", err, report.Aborted)
+	}
+	if report.Count(StatusNotStarted) != 2 {
+		t.Errorf("results = %+v, want the files after a.json not started", report.Files)
+	}
+
+	// Without the option the other files are still downloaded
+	report, _ = client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), Files: files, Concurrency: 1})
+	if report.Aborted != "" || report.Count(StatusDownloaded) != 2 {
+		t.Errorf("results = %+v, want the other two downloaded", report.Files)
+	}
+}
+
+func TestLayoutFilePath(t *testing.T) {
+	layout := Layout{Format: LayoutNested, ModelDir: filepath.Join("out", "org_m")}
+	for repoPath, want := range map[string]string{
+		"config.json":            filepath.Join("out", "org_m", "config.json"),
+		"onnx/model.onnx":        filepath.Join("out", "org_m", "onnx", "model.onnx"),
+		"a/b/c/tokenizer.json":   filepath.Join("out", "org_m", "a", "b", "c", "tokenizer.json"),
+		"../escape.json":         "",
+		"onnx/../../escape.json": "",
+		"/etc/passwd":            "",
+	} {
+		got, err := layout.FilePath(File{Type: TypeFile, Path: repoPath})
+		if want == "" {
+			if err == nil {
+				t.Errorf("FilePath(%q) = %q, want it refused", repoPath, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("FilePath(%q) = %q, %v; want %q", repoPath, got, err, want)
+		}
+	}
+}
+
+func TestLayoutCollisions(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "fp16/config.json", Oid: "a"},
+		{Type: TypeFile, Path: "onnx/config.json", Oid: "b"},
+		{Type: TypeFile, Path: "onnx/model.onnx", Oid: "c"},
+	}
+	out := t.TempDir()
+	flat := Layout{Format: LayoutFlat, ModelDir: filepath.Join(out, "org_m")}
+	stripped := Layout{Format: LayoutNested, ModelDir: flat.ModelDir, Transform: PathTransform{StripComponents: 1}}
+	for _, layout := range []Layout{flat, stripped} {
+		err := layout.CheckCollisions(files)
+		if err == nil || !strings.Contains(err.Error(), "fp16/config.json and onnx/config.json") {
+			t.Errorf("CheckCollisions for %s %+v = %v, want the two config.json files named", layout.Format, layout.Transform, err)
+		}
+	}
+	for _, format := range []string{LayoutNested, LayoutHub} {
+		if err := (Layout{Format: format, ModelDir: flat.ModelDir}).CheckCollisions(files); err != nil {
+			t.Errorf("CheckCollisions for %s = %v, want no collision", format, err)
+		}
+	}
+	if _, err := (Planner{}).PlanFiles(files, []Layout{flat}); err == nil {
+		t.Error("PlanFiles accepted colliding files")
+	}
+
+	// A download fails before anything is written rather than keeping whichever file came last
+	repo := &testRepo{files: map[string][]byte{"fp16/config.json": []byte(`{"a":1}`), "onnx/config.json": []byte(`{"b":2}`)}}
+	client := newTestClient(t, repo)
+	if _, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Layouts: []Layout{flat}}); err == nil {
+		t.Error("DownloadAll accepted colliding files")
+	}
+	if _, err := os.Stat(filepath.Join(flat.ModelDir, "config.json")); !os.IsNotExist(err) {
+		t.Errorf("a colliding file was written (%v)", err)
+	}
+}
+
+func TestSelectionIndexOrder(t *testing.T) {
+	// The weight map names the second shard first; a Go map would lose that order
+	index := []byte(`{"metadata":{},"weight_map":{"z.weight":"model-00002-of-00002.safetensors","a.weight":"model-00001-of-00002.safetensors","b.weight":"model-00002-of-00002.safetensors"}}`)
+	repo := &testRepo{files: map[string][]byte{
+		"config.json":                      []byte(`{}`),
+		"model-00001-of-00002.safetensors": []byte("one"),
+		"model-00002-of-00002.safetensors": []byte("two"),
+		"model.safetensors.index.json":     index,
+		"tokenizer.json":                   []byte(`{}`),
+	}}
+	client := newTestClient(t, repo)
+	files, err := client.ListFiles(context.Background(), "org/m", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selection := Selection{Order: OrderIndex, Shards: func(files []File) ([]string, error) {
+		return client.ShardOrder(context.Background(), "org/m", "main", files)
+	}}
+	result, err := selection.Apply(files, nil)
+	if err != nil || result.ShardErr != nil {
+		t.Fatal(err, result.ShardErr)
+	}
+	want := []string{"model.safetensors.index.json", "config.json", "tokenizer.json", "model-00002-of-00002.safetensors", "model-00001-of-00002.safetensors"}
+	if got := repoPaths(result.Files); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("index order = %v, want %v", got, want)
+	}
+
+	// A broken index falls back to path order and says why
+	repo.files["model.safetensors.index.json"] = []byte(`{"weight_map":[]}`)
+	result, err = selection.Apply(files, nil)
+	if err != nil || result.ShardErr == nil {
+		t.Errorf("Apply with a broken index = %v, shard error %v; want the shard error reported", err, result.ShardErr)
+	}
+}
+
+func TestSelectionLinks(t *testing.T) {
+	files := []File{
+		{Type: TypeFile, Path: "data/train.parquet"},
+		{Type: TypeSymlink, Path: "latest"},
+		{Type: TypeSubmodule, Path: "vendor/tools"},
+	}
+	tests := []struct {
+		selection Selection
+		want      []string
+	}{
+		{Selection{}, []string{"data/train.parquet", "latest", "vendor/tools"}},
+		{Selection{SkipSymlinks: true}, []string{"data/train.parquet", "vendor/tools"}},
+		{Selection{SkipSubmodules: true}, []string{"data/train.parquet", "latest"}},
+		{Selection{SkipSymlinks: true, SkipSubmodules: true}, []string{"data/train.parquet"}},
+	}
+	for _, tt := range tests {
+		result, err := tt.selection.Apply(files, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := repoPaths(result.Files); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%+v kept %v, want %v", tt.selection, got, tt.want)
+		}
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	const max = 40
+	long := strings.Repeat("a", 60) + ".safetensors"
+	short := ShortenName(long, max)
+	if len(short)+len(PartSuffix) > max || !strings.HasSuffix(short, ".safetensors") {
+		t.Errorf("ShortenName(%q) = %q, want at most %d bytes with the extension", long, short, max-len(PartSuffix))
+	}
+	// Names sharing the kept prefix stay distinct
+	if other := ShortenName(strings.Repeat("a", 61)+".safetensors", max); other == short {
+		t.Errorf("two long names both shortened to %q", short)
+	}
+	for _, name := range []string{"config.json", strings.Repeat("a", max-len(PartSuffix))} {
+		if got := ShortenName(name, max); got != name {
+			t.Errorf("ShortenName(%q) = %q, want it unchanged", name, got)
+		}
+	}
+	if got := ShortenName(long, 0); got != long {
+		t.Errorf("ShortenName with no limit = %q", got)
+	}
+	// A cut never falls inside a multi-byte character
+	if got := ShortenName(strings.Repeat("é", 40), max); !utf8.ValidString(got) {
+		t.Errorf("ShortenName split a character: %q", got)
+	}
+
+	got := ShortenPath("onnx/"+long, max)
+	if dir, name := pathpkg.Split(got); dir != "onnx/" || name != short {
+		t.Errorf("ShortenPath = %q, want onnx/%s", got, short)
+	}
+}
+
+func TestWriteNameMap(t *testing.T) {
+	long := strings.Repeat("w", 60) + ".bin"
+	files := []File{{Type: TypeFile, Path: "config.json"}, {Type: TypeFile, Path: "onnx/" + long}}
+
+	dir := t.TempDir()
+	layout := Layout{Format: LayoutNested, ModelDir: dir, MaxName: 40}
+	if err := layout.WriteNameMap(files); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, NamesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"onnx/" + long: "onnx/" + ShortenName(long, 40)}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("name map = %v, want %v", names, want)
+	}
+
+	// Nothing is written when every name fits
+	dir = t.TempDir()
+	layout = Layout{Format: LayoutNested, ModelDir: dir, MaxName: 255}
+	if err := layout.WriteNameMap(files); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, NamesFileName)); !os.IsNotExist(err) {
+		t.Errorf("name map written with nothing shortened: %v", err)
+	}
+}
+
+func TestDownloadFileCancelledMidBody(t *testing.T) {
+	content := []byte(strings.Repeat("x", 4096))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:1024])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client := NewClient()
+	client.Endpoint = server.URL
+
+	// The body stalls after the first KiB, which cancels the run
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var seen int
+	outputPath := filepath.Join(t.TempDir(), "model.bin")
+	done := make(chan error)
+	go func() {
+		_, err := client.DownloadFile(ctx, "org/m", "main", File{Type: TypeFile, Path: "model.bin", Size: int64(len(content))}, []string{outputPath}, FileOptions{
+			Writers: []io.Writer{writerFunc(func(p []byte) {
+				if seen += len(p); seen >= 1024 {
+					cancel()
+				}
+			})},
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("DownloadFile = %v, want the cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DownloadFile kept reading a stalled body after it was cancelled")
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("cancelled download left %s: %v", outputPath, err)
+	}
+	// The partial file stays for the next run to resume
+	if got := ResumeOffset([]string{outputPath}, int64(len(content))); got != 1024 {
+		t.Errorf("ResumeOffset = %d, want 1024", got)
+	}
+}
+
+func TestTreeDiff(t *testing.T) {
+	previous := []File{
+		{Type: TypeFile, Path: "config.json", Size: 10, Oid: "a1"},
+		{Type: TypeFile, Path: "model.safetensors", Size: 100, Oid: "p1", LFS: true, LFSOid: "s1"},
+		{Type: TypeFile, Path: "old.bin", Size: 5, Oid: "o1"},
+		{Type: TypeFile, Path: "tokenizer.json", Size: 20, Oid: "t1"},
+		{Type: TypeFile, Path: "vocab.txt", Size: 30, Oid: "v1"},
+	}
+	current := []File{
+		{Type: TypeFile, Path: "config.json", Size: 10, Oid: "a1"},
+		{Type: TypeFile, Path: "model.safetensors", Size: 100, Oid: "p2", LFS: true, LFSOid: "s2"},
+		{Type: TypeFile, Path: "new.bin", Size: 7, Oid: "n1"},
+		{Type: TypeFile, Path: "tokenizer.json", Size: 21, Oid: "t1"},
+		{Type: TypeSymlink, Path: "vocab.txt", Size: 30, Oid: "v1"},
+	}
+	changed, removed := TreeDiff(previous, current)
+	want := map[string]bool{"model.safetensors": true, "new.bin": true, "tokenizer.json": true, "vocab.txt": true}
+	if fmt.Sprint(changed) != fmt.Sprint(want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+	if fmt.Sprint(removed) != "[old.bin]" {
+		t.Errorf("removed = %v, want [old.bin]", removed)
+	}
+
+	result, err := Selection{Since: "v1", Previous: previous}.Apply(current, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := repoPaths(result.Files); fmt.Sprint(got) != "[model.safetensors new.bin tokenizer.json vocab.txt]" {
+		t.Errorf("Since kept %v", got)
+	}
+	if fmt.Sprint(result.Removed) != "[old.bin]" {
+		t.Errorf("Removed = %v", result.Removed)
+	}
+}
+
+func TestSinceFromTwoRevisions(t *testing.T) {
+	// The Hub serves the listing of each revision; hugdl compares the two itself
+	revisions := map[string]*testRepo{
+		"v1":   {files: map[string][]byte{"config.json": []byte(`{"a":1}`), "model.bin": []byte("weights v1"), "old.txt": []byte("old")}, lfs: map[string]bool{"model.bin": true}},
+		"main": {files: map[string][]byte{"config.json": []byte(`{"a":1}`), "model.bin": []byte("weights v2"), "new.txt": []byte("new")}, lfs: map[string]bool{"model.bin": true}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revision, _ := strings.CutPrefix(r.URL.Path, "/api/models/org/m/tree/")
+		repo, ok := revisions[revision]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path = "/api/models/org/m/tree/main"
+		repo.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	previous, err := client.ListTree(context.Background(), "org/m", "v1", TreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := client.ListTree(context.Background(), "org/m", "main", TreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Selection{Since: "v1", Previous: previous}.Apply(current, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// model.bin changed only in its LFS oid, which the listings carry and a commit list would not
+	if got := repoPaths(result.Files); fmt.Sprint(got) != "[model.bin new.txt]" {
+		t.Errorf("changed since v1 = %v, want [model.bin new.txt]", got)
+	}
+	if fmt.Sprint(result.Removed) != "[old.txt]" {
+		t.Errorf("Removed = %v, want [old.txt]", result.Removed)
+	}
+}
+
+func TestManifestSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := []byte(`[{"path":"config.json","size":7,"oid":"0123abcd"}]`)
+	sig := ed25519.Sign(private, manifest)
+	tampered := []byte(`[{"path":"config.json","size":7,"oid":"0123abce"}]`)
+	client := serveDocuments(t, map[string][]byte{
+		"/files.json":     manifest,
+		"/tampered.json":  tampered,
+		"/files.json.sig": []byte(base64.StdEncoding.EncodeToString(sig) + "\n"),
+		"/raw.sig":        sig,
+		"/junk.sig":       []byte("not a signature"),
+	})
+
+	for _, sigPath := range []string{"/files.json.sig", "/raw.sig"} {
+		if _, err := client.Manifest(context.Background(), client.Endpoint+"/files.json", public, client.Endpoint+sigPath); err != nil {
+			t.Errorf("signature %s: %v", sigPath, err)
+		}
+	}
+	for _, tt := range []struct{ manifest, sig string }{
+		{"/tampered.json", "/files.json.sig"},
+		{"/files.json", "/junk.sig"},
+		{"/files.json", "/missing.sig"},
+	} {
+		if _, err := client.Manifest(context.Background(), client.Endpoint+tt.manifest, public, client.Endpoint+tt.sig); err == nil {
+			t.Errorf("Manifest trusted %s with signature %s", tt.manifest, tt.sig)
+		}
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := VerifyManifestSignature(manifest, sig, other); err == nil {
+		t.Error("a signature verified against another key")
+	}
+
+	// Keys are given directly or in a file
+	encoded := base64.StdEncoding.EncodeToString(public)
+	keyFile := writeTestFile(t, t.TempDir(), "manifest.pub", []byte(encoded+"\n"))
+	for _, value := range []string{encoded, keyFile} {
+		if key, err := ParseManifestKey(value); err != nil || !key.Equal(public) {
+			t.Errorf("ParseManifestKey(%q) = %x, %v", value, key, err)
+		}
+	}
+	for _, value := range []string{base64.StdEncoding.EncodeToString(public[:16]), filepath.Join(t.TempDir(), "missing.pub")} {
+		if _, err := ParseManifestKey(value); err == nil {
+			t.Errorf("ParseManifestKey accepted %q", value)
+		}
+	}
+}
+
+func TestDownloadFileCheckRemoteSize(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 300))
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+
+	// size is what the listing reported when the part file was written
+	for _, size := range []int64{int64(len(content)), int64(len(content)) + 10} {
+		repo.requests = nil
+		outputPath := filepath.Join(t.TempDir(), "model.bin")
+		os.WriteFile(outputPath+PartSuffix, content[:1000], 0644)
+		file := repo.file("model.bin")
+		file.Size = size
+
+		var changed []int64
+		_, err := client.DownloadFile(context.Background(), "org/m", "main", file, []string{outputPath}, FileOptions{
+			CheckRemoteSize: true,
+			NoVerify:        true,
+			OnEvent: func(e Event) {
+				if e.Kind == EventSizeChanged {
+					changed = append(changed, e.Size)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repo.requests) != 2 || !strings.HasPrefix(repo.requests[0], "HEAD ") {
+			t.Fatalf("requests = %q, want a HEAD before the download", repo.requests)
+		}
+		get := repo.requests[1]
+		if size == int64(len(content)) {
+			if len(changed) != 0 || !strings.HasSuffix(get, "bytes=1000-") {
+				t.Errorf("unchanged size: events %v, request %q; want the part resumed", changed, get)
+			}
+		} else if fmt.Sprint(changed) != fmt.Sprint([]int64{int64(len(content))}) || strings.Contains(get, "bytes=") {
+			t.Errorf("changed size: events %v, request %q; want the part restarted", changed, get)
+		}
+	}
+}
+
+func TestDownloadAllDoneMarker(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte(`{"a":1}`), "model.bin": []byte("weights")}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+	out := t.TempDir()
+	nested, _ := NewLayout(LayoutNested, out, "org/m", "main", "c0ffee")
+	hub, _ := NewLayout(LayoutHub, out, "org/m", "main", "c0ffee")
+	opts := DownloadAllOptions{Model: "org/m", Revision: "main", Layouts: []Layout{nested, hub}, DoneMarker: true}
+
+	if _, err := client.DownloadAll(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(nested.ModelDir, DoneMarkerName), filepath.Join(hub.RepoDir, DoneMarkerName)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("no marker after a full download: %v", err)
+		}
+		var marker doneMarker
+		if err := json.Unmarshal(data, &marker); err != nil {
+			t.Fatal(err)
+		}
+		if marker.Model != "org/m" || marker.Commit != "c0ffee" || marker.Files != 2 || marker.Bytes != 14 || !marker.Verified || marker.CompletedAt.IsZero() {
+			t.Errorf("%s = %+v", path, marker)
+		}
+	}
+
+	// A run that leaves a file missing takes the old marker away
+	os.Remove(filepath.Join(nested.ModelDir, "config.json"))
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		http.Error(w, "gone", http.StatusNotFound)
+		return true
+	}
+	if _, err := client.DownloadAll(context.Background(), opts); err == nil {
+		t.Fatal("DownloadAll succeeded without config.json")
+	}
+	for _, path := range []string{filepath.Join(nested.ModelDir, DoneMarkerName), filepath.Join(hub.RepoDir, DoneMarkerName)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s survived a partial download: %v", path, err)
+		}
+	}
+}
+
+func TestDefaultConcurrency(t *testing.T) {
+	for cpus, want := range map[int]int{0: 2, 1: 2, 2: 2, 3: 3, 4: 4, 8: 8, 16: 8, 128: 8} {
+		if got := DefaultConcurrency(cpus); got != want {
+			t.Errorf("DefaultConcurrency(%d) = %d, want %d", cpus, got, want)
+		}
+	}
+}
+
+func TestDownloadAllRetryReasons(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{
+		"busy.json": []byte(`{"a":1}`), "broken.json": []byte(`{"b":2}`), "slow.json": []byte(`{"c":3}`), "reset.json": []byte(`{"d":4}`), "fine.json": []byte(`{}`),
+	}}
+	// Each file but fine.json fails its first request in its own way
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	repo.serve = func(w http.ResponseWriter, r *http.Request, path string) bool {
+		mu.Lock()
+		first := !failed[path]
+		failed[path] = true
+		mu.Unlock()
+		if !first {
+			return false
+		}
+		switch path {
+		case "busy.json":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "broken.json":
+			w.WriteHeader(http.StatusBadGateway)
+		case "slow.json":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		case "reset.json":
+			panic(http.ErrAbortHandler)
+		default:
+			return false
+		}
+		return true
+	}
+	client := newTestClient(t, repo)
+	// Without keep-alives the transport cannot quietly retry the reset on a fresh connection
+	client.HTTPClient = &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 200 * time.Millisecond, DisableKeepAlives: true}}
+
+	report, err := client.DownloadAll(context.Background(), DownloadAllOptions{Model: "org/m", Dest: t.TempDir(), Concurrency: 5, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]RetryCounts{
+		"busy.json":   {RetryRateLimited: 1},
+		"broken.json": {RetryServerError: 1},
+		"slow.json":   {RetryTimeout: 1},
+		"reset.json":  {RetryConnection: 1},
+		"fine.json":   {},
+	}
+	for _, result := range report.Files {
+		if fmt.Sprint(result.Retries) != fmt.Sprint(want[result.File.Path]) {
+			t.Errorf("%s retries = %v, want %v", result.File.Path, result.Retries, want[result.File.Path])
+		}
+	}
+	total := report.Retries()
+	if total.Total() != 4 || total.String() != "1 connection, 1 rate_limited, 1 server_error, 1 timeout" {
+		t.Errorf("Retries = %v (%d)", total, total.Total())
+	}
+}
+
+func TestRetriesForSize(t *testing.T) {
+	const gib = 1 << 30
+	tests := []struct {
+		base, perGB int
+		size        int64
+		want        int
+	}{
+		{3, 0, 50 * gib, 3},
+		{2, 1, 0, 2},
+		{2, 1, 4 << 10, 2},
+		{2, 1, 500e6, 2},
+		{2, 1, gib - 1, 2},
+		{2, 1, gib, 3},
+		{2, 1, 5e9, 6},
+		{2, 2, 10 * gib, 22},
+		{0, 1, 3 * gib, 3},
+	}
+	for _, tt := range tests {
+		if got := RetriesForSize(tt.base, tt.perGB, tt.size); got != tt.want {
+			t.Errorf("RetriesForSize(%d, %d, %d) = %d, want %d", tt.base, tt.perGB, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadFileVerifyResume(t *testing.T) {
+	content := make([]byte, 200<<10)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	repo := &testRepo{files: map[string][]byte{"model.bin": content}, lfs: map[string]bool{"model.bin": true}}
+	client := newTestClient(t, repo)
+
+	corrupt := append([]byte{}, content[:150000]...)
+	corrupt[149000] ^= 0xff
+	tests := []struct {
+		name    string
+		part    []byte
+		resumed bool
+	}{
+		{"truncated", content[:123457], true},
+		{"short", content[:1000], true},
+		{"corrupt last block", corrupt, false},
+		{"longer than the file", append(content, 'x'), false},
+	}
+	for _, tt := range tests {
+		repo.requests = nil
+		outputPath := filepath.Join(t.TempDir(), "model.bin")
+		os.WriteFile(outputPath+PartSuffix, tt.part, 0644)
+
+		var rejected []error
+		_, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("model.bin"), []string{outputPath}, FileOptions{
+			VerifyResume: true,
+			OnEvent: func(e Event) {
+				if e.Kind == EventResumeRejected {
+					rejected = append(rejected, e.Err)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+			t.Errorf("%s: the download does not hold the file", tt.name)
+		}
+		last := repo.requests[len(repo.requests)-1]
+		if resumed := strings.HasSuffix(last, fmt.Sprintf("bytes=%d-", len(tt.part))); resumed != tt.resumed {
+			t.Errorf("%s: last request %q, want resumed %v (rejected: %v)", tt.name, last, tt.resumed, rejected)
+		}
+		if tt.name == "corrupt last block" && len(rejected) != 1 {
+			t.Errorf("%s: rejections %v, want one", tt.name, rejected)
+		}
+	}
+
+	// Corruption before the compared block is left to verification
+	damaged := append([]byte{}, content[:100000]...)
+	damaged[0] ^= 0xff
+	outputPath := filepath.Join(t.TempDir(), "model.bin")
+	os.WriteFile(outputPath+PartSuffix, damaged, 0644)
+	_, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("model.bin"), []string{outputPath}, FileOptions{VerifyResume: true})
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) {
+		t.Errorf("DownloadFile = %v, want a checksum error", err)
+	}
+}
+
+func TestCheckPartTail(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	dir := t.TempDir()
+	good := writeTestFile(t, dir, "good.part", content[:40])
+	bad := writeTestFile(t, dir, "bad.part", append(append([]byte{}, content[:39]...), '!'))
+	short := writeTestFile(t, dir, "short.part", content[:20])
+
+	response := func(status int, contentRange string, body []byte) *http.Response {
+		header := http.Header{}
+		header.Set("Content-Range", contentRange)
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(string(body)))}
+	}
+	tests := []struct {
+		parts []string
+		resp  *http.Response
+		ok    bool
+	}{
+		{[]string{good}, response(http.StatusPartialContent, "bytes 0-39/100", content[:40]), true},
+		{[]string{good, bad}, response(http.StatusPartialContent, "bytes 0-39/100", content[:40]), false},
+		{[]string{short}, response(http.StatusPartialContent, "bytes 0-39/100", content[:40]), false},
+		{[]string{good}, response(http.StatusPartialContent, "bytes 10-49/100", content[10:50]), false},
+		{[]string{good}, response(http.StatusPartialContent, "bytes 0-39/100", content[:30]), false},
+		{[]string{bad}, response(http.StatusOK, "", content), true}, // no range, so nothing to compare
+	}
+	for i, tt := range tests {
+		if err := checkPartTail(tt.parts, 40, tt.resp); (err == nil) != tt.ok {
+			t.Errorf("case %d: checkPartTail = %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestReadGlobs(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "include.txt", []byte("# weights\n*Q4_K_M*.gguf\n\n  *.json  \n   # indented comment\nnotes, v2.txt\r\n"))
+	globs, err := ReadGlobs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	for _, glob := range globs {
+		patterns = append(patterns, glob.Pattern)
+	}
+	if fmt.Sprint(patterns) != "[*Q4_K_M*.gguf *.json notes, v2.txt]" {
+		t.Errorf("patterns = %q", patterns)
+	}
+	if !globs[2].Match("notes, v2.txt") {
+		t.Error("a comma in a pattern file was not kept as part of the pattern")
+	}
+
+	bad := writeTestFile(t, dir, "bad.txt", []byte("*.json\nmodel-[12.bin\n"))
+	if _, err := ReadGlobs(bad); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("ReadGlobs(bad) = %v, want an error naming the file", err)
+	}
+	if _, err := ReadGlobs(filepath.Join(dir, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("ReadGlobs(missing) = %v", err)
+	}
+}
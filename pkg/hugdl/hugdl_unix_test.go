@@ -0,0 +1,53 @@
+//go:build unix
+
+package hugdl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDownloadFileMode(t *testing.T) {
+	repo := &testRepo{files: map[string][]byte{"config.json": []byte("{}")}}
+	client := newTestClient(t, repo)
+	defer syscall.Umask(syscall.Umask(0077))
+
+	// Without a mode the umask applies; an explicit mode is exact regardless of it
+	for mode, want := range map[os.FileMode]os.FileMode{0: 0600, 0644: 0644, 0640: 0640} {
+		outputPath := filepath.Join(t.TempDir(), "config.json")
+		if _, err := client.DownloadFile(context.Background(), "org/m", "main", repo.file("config.json"), []string{outputPath}, FileOptions{FileMode: mode}); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.Mode().Perm(); got != want {
+			t.Errorf("FileMode %04o under umask 077 gave %04o, want %04o", mode, got, want)
+		}
+	}
+}
+
+func TestNormalizeLineEndingsKeepsMode(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(0077))
+	for _, mode := range []os.FileMode{0600, 0640, 0644} {
+		path := writeTestFile(t, t.TempDir(), "config.json", []byte("{\n}\n"))
+		os.Chmod(path, mode)
+		if changed, err := normalizeLineEndings(path, LineEndingsCRLF); err != nil || !changed {
+			t.Fatalf("normalizeLineEndings = %v, %v; want true, nil", changed, err)
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.Mode().Perm(); got != mode {
+			t.Errorf("mode %04o became %04o after normalizing", mode, got)
+		}
+		if _, err := os.Stat(path + PartSuffix); !os.IsNotExist(err) {
+			t.Errorf("the temporary file was left behind (%v)", err)
+		}
+	}
+}
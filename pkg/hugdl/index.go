@@ -0,0 +1,111 @@
+package hugdl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IndexFileName is the machine-readable listing written with DownloadAllOptions.WriteIndex.
+// Like the other sidecars it is prefixed so it never replaces a repo file of the same name.
+const IndexFileName = ".hugdl-index.json"
+
+// modelIndex describes the files present in one output layout
+type modelIndex struct {
+	Model    string       `json:"model"`
+	Revision string       `json:"revision"`
+	Commit   string       `json:"commit,omitempty"`
+	Files    []indexEntry `json:"files"`
+}
+
+// indexEntry is one file of a modelIndex
+type indexEntry struct {
+	Path   string `json:"path"`
+	Local  string `json:"local"`
+	Size   int64  `json:"size"`
+	Oid    string `json:"oid,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	URL    string `json:"url"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// writeIndex writes the index sidecar for layout, listing only the files that exist locally
+// so the index never describes downloads that failed. Hub mode keeps it in the repo
+// directory rather than inside the snapshot.
+func (c *Client) writeIndex(layout Layout, files []File, state *State) error {
+	index := modelIndex{Model: state.Model, Revision: state.Revision, Commit: state.Commit, Files: []indexEntry{}}
+	for _, file := range files {
+		local, err := layout.localPath(file)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(local); err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(layout.ModelDir, local)
+		if err != nil {
+			return err
+		}
+
+		entry := indexEntry{
+			Path:   file.Path,
+			Local:  filepath.ToSlash(rel),
+			Size:   file.Size,
+			Oid:    file.ExpectedOid(),
+			URL:    c.resolveURL(state.Model, state.Revision, file.Path),
+			Commit: state.Files[file.Path].Commit,
+		}
+		if file.LFS {
+			entry.SHA256 = entry.Oid
+		}
+		index.Files = append(index.Files, entry)
+	}
+
+	dir := layout.ModelDir
+	if layout.Format == LayoutHub {
+		dir = layout.RepoDir
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, IndexFileName), data, 0644)
+}
+
+// DoneMarkerName is written with DownloadAllOptions.DoneMarker once every file is in place
+const DoneMarkerName = ".hugdl-complete"
+
+// doneMarker tells orchestration that a model finished downloading
+type doneMarker struct {
+	Model       string    `json:"model"`
+	Revision    string    `json:"revision"`
+	Commit      string    `json:"commit,omitempty"`
+	Files       int       `json:"files"`
+	Bytes       int64     `json:"bytes"`
+	Verified    bool      `json:"verified"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// doneMarkerPath returns where layout keeps its marker: next to the files, or in the
+// repo directory in hub mode like the index
+func (l Layout) doneMarkerPath() string {
+	if l.Format == LayoutHub {
+		return filepath.Join(l.RepoDir, DoneMarkerName)
+	}
+	return filepath.Join(l.ModelDir, DoneMarkerName)
+}
+
+// writeDoneMarker writes the marker through a temporary file so a watcher never
+// reads it half-written
+func (l Layout) writeDoneMarker(marker doneMarker) error {
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := l.doneMarkerPath()
+	if err := os.WriteFile(path+PartSuffix, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+PartSuffix, path)
+}
@@ -0,0 +1,448 @@
+package hugdl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"downloader/internal/diskspace"
+	"downloader/internal/units"
+)
+
+// Output layouts accepted by NewLayout
+const (
+	LayoutNested = "nested"
+	LayoutFlat   = "flat"
+	LayoutHub    = "hub"
+)
+
+// Layout maps repo files to local paths for one output format
+type Layout struct {
+	Format string
+	// ModelDir is where the model files appear (the snapshot directory in hub mode)
+	ModelDir string
+	// RepoDir is hub mode's <output>/models--<org>--<name>
+	RepoDir string
+	// CacheDir, if set, holds the sidecar files and partial downloads instead of the
+	// model directory
+	CacheDir string
+	// Transform maps repo paths to local paths in the nested layout
+	Transform PathTransform
+	// MaxName is the longest local file or directory name in bytes; 0 = no limit
+	MaxName  int
+	Revision string
+	Commit   string
+}
+
+// ModelDirName is the directory name model gets in the nested and flat layouts
+func ModelDirName(model string) string {
+	return strings.ReplaceAll(model, "/", "_")
+}
+
+// NewLayout resolves the directories used by format under outputDir. The hub layout
+// names snapshots after commit, which it therefore needs.
+func NewLayout(format, outputDir, model, revision, commit string) (Layout, error) {
+	layout := Layout{Format: format, Revision: revision, Commit: commit}
+
+	switch format {
+	case LayoutNested, LayoutFlat:
+		layout.ModelDir = filepath.Join(outputDir, ModelDirName(model))
+	case LayoutHub:
+		if commit == "" {
+			return layout, fmt.Errorf("hub layout needs the commit hash of %s", model)
+		}
+		layout.RepoDir = filepath.Join(outputDir, "models--"+strings.ReplaceAll(model, "/", "--"))
+		layout.ModelDir = filepath.Join(layout.RepoDir, "snapshots", commit)
+	default:
+		return layout, fmt.Errorf("unknown output format %q (want nested, flat or hub)", format)
+	}
+
+	return layout, nil
+}
+
+// Prepare creates the layout's directories and, in hub mode, records the revision's commit
+func (l Layout) Prepare() error {
+	if err := os.MkdirAll(l.ModelDir, 0755); err != nil {
+		return err
+	}
+	// Commit hashes are not refs; only branches and tags are recorded
+	if l.Format != LayoutHub || l.Revision == l.Commit {
+		return nil
+	}
+
+	refPath := filepath.Join(l.RepoDir, "refs", filepath.FromSlash(l.Revision))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(refPath, []byte(l.Commit), 0644)
+}
+
+// FilePath returns where the content of file is written
+func (l Layout) FilePath(file File) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+		return "", fmt.Errorf("refusing unsafe repo path %q", file.Path)
+	}
+
+	switch l.Format {
+	case LayoutFlat:
+		return filepath.Join(l.ModelDir, ShortenName(file.Name(), l.MaxName)), nil
+	case LayoutHub:
+		oid := file.ExpectedOid()
+		if oid == "" {
+			return "", fmt.Errorf("no oid reported for %s", file.Path)
+		}
+		return filepath.Join(l.RepoDir, "blobs", oid), nil
+	default:
+		local, err := l.Transform.apply(file.Path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(l.ModelDir, filepath.FromSlash(ShortenPath(local, l.MaxName))), nil
+	}
+}
+
+// CheckCollisions reports files that would be written to the same path, such as
+// files with the same name in different directories of a flat layout. Hub mode
+// stores content by oid, where a shared blob is not a collision.
+func (l Layout) CheckCollisions(files []File) error {
+	if l.Format == LayoutHub {
+		return nil
+	}
+	var errs []error
+	written := map[string]string{}
+	for _, file := range files {
+		path, err := l.FilePath(file)
+		if err != nil {
+			continue // reported when the file is downloaded
+		}
+		if other, ok := written[path]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s would both be written to %s", other, file.Path, path))
+			continue
+		}
+		written[path] = file.Path
+	}
+	return errors.Join(errs...)
+}
+
+// PartPath returns where file is written while it downloads: next to its FilePath,
+// or with a CacheDir under parts/ there, in a folder of its own for each output
+// directory because layouts of several output directories may share one CacheDir
+func (l Layout) PartPath(file File) (string, error) {
+	path, err := l.FilePath(file)
+	if err != nil {
+		return "", err
+	}
+	if l.CacheDir == "" {
+		return path + PartSuffix, nil
+	}
+	root := l.ModelDir
+	if l.Format == LayoutHub {
+		root = l.RepoDir
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(l.CacheDir, "parts", hex.EncodeToString(sum[:6]), rel) + PartSuffix, nil
+}
+
+// SnapshotPath returns the hub mode snapshot entry for file
+func (l Layout) SnapshotPath(file File) string {
+	return filepath.Join(l.ModelDir, filepath.FromSlash(ShortenPath(file.Path, l.MaxName)))
+}
+
+// localPath returns where file appears: its snapshot entry in hub mode, its content otherwise
+func (l Layout) localPath(file File) (string, error) {
+	if l.Format == LayoutHub {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return "", fmt.Errorf("refusing unsafe repo path %q", file.Path)
+		}
+		return l.SnapshotPath(file), nil
+	}
+	return l.FilePath(file)
+}
+
+// Finalize makes a written file visible in the model directory.
+// In hub mode the snapshot entry links to the blob, falling back to a hardlink
+// where symlinks are unavailable (e.g. Windows without developer mode).
+func (l Layout) Finalize(file File) error {
+	if l.Format != LayoutHub {
+		return nil
+	}
+
+	blob, err := l.FilePath(file)
+	if err != nil {
+		return err
+	}
+	link := l.SnapshotPath(file)
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace snapshot entry: %w", err)
+	}
+
+	target, err := filepath.Rel(filepath.Dir(link), blob)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, link); err != nil {
+		if err := os.Link(blob, link); err != nil {
+			return fmt.Errorf("failed to link snapshot entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// StateDir returns the directory holding hugdl's sidecar files
+func (l Layout) StateDir() string {
+	if l.CacheDir != "" {
+		return l.CacheDir
+	}
+	if l.Format == LayoutHub {
+		return l.RepoDir
+	}
+	return l.ModelDir
+}
+
+// Quarantine moves a file that failed verification from outputPath into the
+// layout's quarantine/ folder and returns its new location
+func (l Layout) Quarantine(outputPath string, file File) (string, error) {
+	dest := filepath.Join(l.StateDir(), "quarantine", filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(outputPath, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// filePaths returns where each layout writes the content of file
+func filePaths(layouts []Layout, file File) ([]string, error) {
+	paths := make([]string, len(layouts))
+	for i, layout := range layouts {
+		path, err := layout.FilePath(file)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// partPaths returns where each layout keeps the partial download of file
+func partPaths(layouts []Layout, file File) ([]string, error) {
+	paths := make([]string, len(layouts))
+	for i, layout := range layouts {
+		path, err := layout.PartPath(file)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// finalizeAll runs write for each output path and finalizes the layouts where it succeeded.
+// All failures are joined into the returned error.
+func finalizeAll(layouts []Layout, outputPaths []string, file File, write func(outputPath string) error) error {
+	var errs []error
+	for i, layout := range layouts {
+		err := write(outputPaths[i])
+		if err == nil {
+			err = layout.Finalize(file)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PathTransform derives a local relative path from a repo path, e.g. to strip
+// a dataset's top-level folder or move files under a split directory
+type PathTransform struct {
+	StripPrefix     string
+	StripComponents int    // leading path segments dropped after StripPrefix, like tar
+	Template        string // placeholders: {path}, {dir}, {name}; empty means "{path}"
+}
+
+// stripped removes the prefix and leading components from repoPath.
+// ok is false when no path is left, e.g. a top-level file with StripComponents 1.
+func (t PathTransform) stripped(repoPath string) (local string, ok bool) {
+	local = strings.TrimPrefix(repoPath, t.StripPrefix)
+	local = strings.TrimPrefix(local, "/")
+
+	segments := strings.Split(local, "/")
+	if t.StripComponents >= len(segments) {
+		return "", false
+	}
+	return strings.Join(segments[t.StripComponents:], "/"), true
+}
+
+// apply returns the transformed path, refusing results that would escape the output directory
+func (t PathTransform) apply(repoPath string) (string, error) {
+	local, ok := t.stripped(repoPath)
+	if !ok {
+		return "", fmt.Errorf("%s has no path left after stripping %d components", repoPath, t.StripComponents)
+	}
+
+	if t.Template != "" {
+		dir := pathpkg.Dir(local)
+		if dir == "." {
+			dir = ""
+		}
+		local = strings.NewReplacer(
+			"{path}", local,
+			"{dir}", dir,
+			"{name}", pathpkg.Base(local),
+		).Replace(t.Template)
+		// An empty {dir} leaves a leading slash for top-level files
+		local = strings.TrimPrefix(pathpkg.Clean(local), "/")
+	}
+
+	if local == "" || local == "." || !filepath.IsLocal(filepath.FromSlash(local)) {
+		return "", fmt.Errorf("refusing unsafe local path %q for %s", local, repoPath)
+	}
+	return local, nil
+}
+
+// MinNameLength is the smallest Layout.MaxName; shorter limits leave no room
+// for the hash suffix and the .part extension
+const MinNameLength = 24
+
+// ShortenName truncates name to fit in max bytes, or returns it unchanged if it
+// already fits or max is 0. The result keeps the extension and ends the stem with a
+// hash of the full name so long names sharing a prefix stay distinct. Room is left
+// for PartSuffix so in-progress downloads fit too.
+func ShortenName(name string, max int) string {
+	max -= len(PartSuffix)
+	if max <= 0 || len(name) <= max {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	ext := pathpkg.Ext(name)
+	if len(ext)+len(suffix) >= max {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	keep := max - len(suffix) - len(ext)
+	// Never cut a multi-byte character in half
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	return stem[:keep] + suffix + ext
+}
+
+// ShortenPath applies ShortenName to every segment of a slash-separated path
+func ShortenPath(path string, max int) string {
+	if max <= 0 {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = ShortenName(segment, max)
+	}
+	return strings.Join(segments, "/")
+}
+
+// NamesFileName records which repo paths were shortened by Layout.MaxName
+const NamesFileName = ".hugdl-names.json"
+
+// WriteNameMap records the local path of every file whose name was shortened,
+// keyed by repo path, so tools can find them again. Nothing is written when no
+// name needed shortening.
+func (l Layout) WriteNameMap(files []File) error {
+	unlimited := l
+	unlimited.MaxName = 0
+
+	names := map[string]string{}
+	for _, file := range files {
+		short, err := l.localPath(file)
+		if err != nil {
+			continue
+		}
+		if full, err := unlimited.localPath(file); err != nil || full == short {
+			continue
+		}
+		rel, err := filepath.Rel(l.ModelDir, short)
+		if err != nil {
+			return err
+		}
+		names[file.Path] = filepath.ToSlash(rel)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.StateDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(l.StateDir(), NamesFileName), data, 0644)
+}
+
+// freeSpaceMargin is the headroom CheckFreeSpace requires beyond the download itself:
+// a twentieth of it, but at least minFreeSpaceMargin
+const (
+	freeSpaceMargin    = 20
+	minFreeSpaceMargin = 64 << 20
+)
+
+// SpaceError reports a volume too small for the files still to download
+type SpaceError struct {
+	Dir       string
+	Needed    int64 // bytes still to download
+	Required  int64 // Needed plus headroom
+	Available uint64
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("not enough free space for %s: %s to download needs %s with headroom, only %s available",
+		e.Dir, units.FormatSize(e.Needed), units.FormatSize(e.Required), units.FormatSize(int64(e.Available)))
+}
+
+// CheckFreeSpace returns a *SpaceError if the volume holding the model directory has
+// less room than the files still missing there need, plus a safety margin. Files
+// already present at their full size are not counted. Any other error means the
+// free space could not be determined.
+func (l Layout) CheckFreeSpace(files []File) error {
+	var needed int64
+	for _, file := range files {
+		path, err := l.FilePath(file)
+		if err != nil || checkLocalSize(path, file) == nil {
+			continue
+		}
+		needed += file.Size
+	}
+	if needed == 0 {
+		return nil
+	}
+
+	free, err := diskspace.Available(l.ModelDir)
+	if err != nil {
+		return fmt.Errorf("could not check free space for %s: %w", l.ModelDir, err)
+	}
+	required := needed + max(needed/freeSpaceMargin, minFreeSpaceMargin)
+	if uint64(required) > free {
+		return &SpaceError{Dir: l.ModelDir, Needed: needed, Required: required, Available: free}
+	}
+	return nil
+}
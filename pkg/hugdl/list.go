@@ -0,0 +1,129 @@
+package hugdl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// TreeURL returns the first page of the recursive listing of model at revision.
+// An empty revision means DefaultRevision.
+func (c *Client) TreeURL(model, revision string) string {
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	return fmt.Sprintf("%s/api/models/%s/tree/%s?recursive=true", c.Endpoint, model, neturl.PathEscape(revision))
+}
+
+// Page is one page of a tree listing
+type Page struct {
+	Files       []File
+	Next        string // URL of the next page, if any
+	ETag        string
+	NotModified bool // the server answered 304 to If-None-Match
+}
+
+// ListPage fetches one page of a tree listing, sending If-None-Match when etag is set.
+// Directories are left out; symlinks and submodules are kept with their Type.
+func (c *Client) ListPage(ctx context.Context, url, etag string) (Page, error) {
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		return Page{}, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to fetch model info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return Page{NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, newStatusError(resp)
+	}
+
+	var items []struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+		Size int64  `json:"size,omitempty"`
+		Oid  string `json:"oid"`
+		LFS  *struct {
+			Oid string `json:"oid"`
+		} `json:"lfs,omitempty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return Page{}, fmt.Errorf("failed to decode API response: %w", err)
+	}
+
+	var files []File
+	for _, item := range items {
+		if item.Type == "commit" {
+			item.Type = TypeSubmodule
+		}
+		if item.Type != TypeFile && item.Type != TypeSymlink && item.Type != TypeSubmodule {
+			continue
+		}
+		file := File{Type: item.Type, Path: item.Path, Size: item.Size, Oid: item.Oid}
+		if item.LFS != nil {
+			file.LFS = true
+			file.LFSOid = item.LFS.Oid
+		}
+		files = append(files, file)
+	}
+
+	return Page{
+		Files: files,
+		Next:  nextPageURL(resp.Header.Get("Link")),
+		ETag:  resp.Header.Get("ETag"),
+	}, nil
+}
+
+// ListFiles returns every file in model at revision, following the listing's pages.
+// Directories, symlinks and submodules are left out. An empty revision means DefaultRevision.
+func (c *Client) ListFiles(ctx context.Context, model, revision string) ([]File, error) {
+	var files []File
+	seen := map[string]bool{}
+	for url := c.TreeURL(model, revision); url != ""; {
+		// A server sending a page's own link as "next" must not keep us here forever
+		if seen[url] {
+			return nil, fmt.Errorf("listing pages of %s loop back to %s", model, url)
+		}
+		seen[url] = true
+
+		page, err := c.ListPage(ctx, url, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", model, err)
+		}
+		for _, file := range page.Files {
+			if file.Type == TypeFile {
+				files = append(files, file)
+			}
+		}
+		url = page.Next
+	}
+	return files, nil
+}
+
+// nextPageURL extracts the rel="next" target from a Link header
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		fields := strings.Split(part, ";")
+		if len(fields) < 2 {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(fields[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
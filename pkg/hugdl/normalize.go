@@ -0,0 +1,58 @@
+package hugdl
+
+import (
+	"fmt"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// Line endings accepted by DownloadAllOptions.LineEndings
+const (
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// textExtensions lists the file types whose line endings may be rewritten
+var textExtensions = map[string]bool{".json": true, ".txt": true, ".md": true}
+
+// IsTextFile reports whether repoPath is a known text format
+func IsTextFile(repoPath string) bool {
+	return textExtensions[strings.ToLower(pathpkg.Ext(repoPath))]
+}
+
+// normalizeLineEndings rewrites path with LF or CRLF line endings, leaving it untouched
+// if already consistent, and reports whether it changed the file. The new content is
+// written to a temporary file with path's mode and renamed over it, so path is never
+// half-written and keeps the permissions it was given.
+func normalizeLineEndings(path, eol string) (bool, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	normalized := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if eol == LineEndingsCRLF {
+		normalized = strings.ReplaceAll(normalized, "\n", "\r\n")
+	}
+	if normalized == string(data) {
+		return false, nil
+	}
+	tmp := path + PartSuffix
+	err = os.WriteFile(tmp, []byte(normalized), stat.Mode().Perm())
+	if err == nil {
+		// WriteFile's mode is narrowed by the umask
+		err = os.Chmod(tmp, stat.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to normalize line endings: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,160 @@
+package hugdl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"downloader/internal/units"
+)
+
+// Actions a run can take for a file
+const (
+	ActionDownload = "download"
+	ActionResume   = "resume"
+	ActionSkip     = "skip"
+	ActionLink     = "link"
+)
+
+// FilePlan is the action planned for one file and why
+type FilePlan struct {
+	File     File
+	Action   string
+	Reason   string
+	Existing string // matching copy in a reference directory, if that decided the action
+	Bytes    int64  // bytes that would be transferred
+}
+
+// Planner decides what to do with each file before anything is written. The zero
+// value downloads files that are missing or have the wrong size.
+type Planner struct {
+	// ExistingDirs are reference directories whose copies, matched by repo path or
+	// file name, size and oid, stand in for a download
+	ExistingDirs []string
+	// Hardlink links those copies into place instead of just skipping the file
+	Hardlink bool
+	// DeepSync hashes local copies instead of trusting their size
+	DeepSync bool
+	// Force downloads every file again
+	Force bool
+	// State, if set, supplies the expected size and hash of rewritten local copies
+	State *State
+	// Hasher hashes local copies; nil hashes without a bound or cache
+	Hasher *Hasher
+	// Explain, if set, is told the reasons behind each plan
+	Explain *Explainer
+}
+
+// Plan returns the action for file given its output paths, with partial downloads
+// kept next to them
+func (p Planner) Plan(file File, outputPaths []string) FilePlan {
+	return p.planParts(file, outputPaths, FileOptions{}.partFiles(outputPaths))
+}
+
+// planParts is Plan for partial downloads kept at partPaths
+func (p Planner) planParts(file File, outputPaths, partPaths []string) FilePlan {
+	plan := p.plan(file, outputPaths, partPaths)
+	plan.File = file
+	return plan
+}
+
+// plan decides the action for file; planParts fills in the file itself
+func (p Planner) plan(file File, outputPaths, partPaths []string) FilePlan {
+	if existing := findExisting(p.ExistingDirs, file, p.Hasher); existing != "" {
+		p.Explain.Note(file, "same size and hash as %s", existing)
+		if p.Hardlink {
+			return FilePlan{Action: ActionLink, Reason: "hardlinking existing copies", Existing: existing}
+		}
+		return FilePlan{Action: ActionSkip, Reason: "found in a reference directory", Existing: existing}
+	}
+	if len(p.ExistingDirs) > 0 {
+		p.Explain.Note(file, "no matching copy in the reference directories")
+	}
+
+	// A copy with rewritten line endings is compared with what was recorded for it
+	local := p.State.LocalFile(file)
+	switch {
+	case p.Force:
+		p.Explain.Note(file, "downloads are forced")
+	case p.DeepSync:
+		err := checkLocalCopies(outputPaths, local, p.Hasher)
+		if err == nil {
+			return FilePlan{Action: ActionSkip, Reason: "unchanged"}
+		}
+		p.Explain.Note(file, "local copy differs: %v", err)
+	default:
+		err := checkLocalSizes(outputPaths, local)
+		if err == nil {
+			return FilePlan{Action: ActionSkip, Reason: "already present"}
+		}
+		if !errors.Is(err, errMissing) {
+			p.Explain.Note(file, "local copy is incomplete: %v", err)
+		}
+	}
+
+	// Partial files left by an interrupted run only need the rest of the file
+	if offset := partOffset(partPaths, file.Size); offset > 0 {
+		p.Explain.Note(file, "%s of %s already downloaded", units.FormatSize(offset), units.FormatSize(file.Size))
+		return FilePlan{Action: ActionResume, Reason: "resuming an interrupted download", Bytes: file.Size - offset}
+	}
+
+	return FilePlan{Action: ActionDownload, Reason: "selected for download", Bytes: file.Size}
+}
+
+// PlanFiles plans every file for layouts without writing anything, e.g. for a dry run
+func (p Planner) PlanFiles(files []File, layouts []Layout) ([]FilePlan, error) {
+	for _, layout := range layouts {
+		if err := layout.CheckCollisions(files); err != nil {
+			return nil, err
+		}
+	}
+	plans := make([]FilePlan, 0, len(files))
+	for _, file := range files {
+		outputPaths, err := filePaths(layouts, file)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := partPaths(layouts, file)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p.planParts(file, outputPaths, parts))
+	}
+	return plans, nil
+}
+
+// findExisting returns the path of a local copy of file in one of dirs, or "" if none matches.
+// A candidate must have the expected size and, when the API reported one, the same oid.
+func findExisting(dirs []string, file File, hasher *Hasher) string {
+	for _, dir := range dirs {
+		for _, candidate := range []string{filepath.Join(dir, filepath.FromSlash(file.Path)), filepath.Join(dir, file.Name())} {
+			stat, err := os.Stat(candidate)
+			if err != nil || !stat.Mode().IsRegular() || stat.Size() != file.Size {
+				continue
+			}
+			if expected := file.ExpectedOid(); expected != "" {
+				actual, err := hasher.FileOid(candidate, file.LFS)
+				if err != nil || actual != expected {
+					continue
+				}
+			}
+			return candidate
+		}
+	}
+	return ""
+}
+
+// linkExisting hardlinks src to dst, replacing any file already at dst
+func linkExisting(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	if err := os.Link(src, dst); err != nil {
+		return fmt.Errorf("failed to hardlink: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package hugdl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryReason classifies why a request was retried
+type RetryReason string
+
+// Reasons for retrying a request
+const (
+	RetryTimeout     RetryReason = "timeout"      // the connection or response timed out
+	RetryConnection  RetryReason = "connection"   // any other network error, e.g. a reset connection
+	RetryRateLimited RetryReason = "rate_limited" // the server answered 429
+	RetryServerError RetryReason = "server_error" // the server answered 5xx
+)
+
+// retryReason classifies a failed request by its error or, without one, its status
+func retryReason(err error, status int) RetryReason {
+	var netErr net.Error
+	switch {
+	case err != nil && errors.As(err, &netErr) && netErr.Timeout():
+		return RetryTimeout
+	case err != nil:
+		return RetryConnection
+	case status == http.StatusTooManyRequests:
+		return RetryRateLimited
+	}
+	return RetryServerError
+}
+
+// RetryCounts tallies retries by reason
+type RetryCounts map[RetryReason]int
+
+// Total returns the number of retries of every reason
+func (c RetryCounts) Total() int {
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// add adds the retries in other to c
+func (c RetryCounts) add(other RetryCounts) {
+	for reason, n := range other {
+		c[reason] += n
+	}
+}
+
+// String lists the counts by reason, e.g. "2 timeout, 1 rate_limited"
+func (c RetryCounts) String() string {
+	var parts []string
+	for reason, n := range c {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, reason))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// doWithRetries sends the request built by newRequest, retrying while budget lasts on
+// network errors, 429 and 5xx responses with exponential backoff and jitter. A Retry-After
+// header on the response is honored instead of the computed delay. Other responses,
+// including 404, are returned for the caller to handle.
+func doWithRetries(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error), budget *retryBudget) (*http.Response, error) {
+	for {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		var reason error
+		var kind RetryReason
+		var wait time.Duration
+		switch {
+		case err != nil:
+			kind = retryReason(err, 0)
+			err = fmt.Errorf("failed to download: %w", err)
+			reason = err
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			kind = retryReason(nil, resp.StatusCode)
+			reason = fmt.Errorf("status %d", resp.StatusCode)
+			wait, _ = retryAfter(resp.Header)
+			if budget.spent() {
+				return resp, nil
+			}
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to download: %w", ctx.Err())
+		}
+		if budget.spent() {
+			return nil, err
+		}
+		if err := budget.wait(ctx, kind, reason, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryBudget counts the retries left for one download across all of its requests
+type retryBudget struct {
+	max    int
+	used   int
+	notify func(Event)
+}
+
+// spent reports whether no retries are left
+func (b *retryBudget) spent() bool {
+	return b.used >= b.max
+}
+
+// wait uses up a retry, reporting it as an EventRetry for kind, and sleeps for delay
+// or, if zero, the backoff for the retries made so far
+func (b *retryBudget) wait(ctx context.Context, kind RetryReason, reason error, delay time.Duration) error {
+	if delay == 0 {
+		delay = backoffDelay(b.used)
+	}
+	b.used++
+	b.notify(Event{Kind: EventRetry, Attempt: b.used + 1, Max: b.max + 1, Delay: delay, Reason: kind, Err: reason})
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to download: %w", ctx.Err())
+	}
+}
+
+// errorReader remembers the error its reader returned, telling a dropped connection
+// apart from a failed write when both surface through io.Copy
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// backoffDelay returns the wait before retry number attempt+1: 1s doubling up to 30s, plus up to 50% jitter
+func backoffDelay(attempt int) time.Duration {
+	delay := time.Second << min(attempt, 5)
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// maxRetryAfter is the longest a Retry-After header can make a retry wait, so a
+// misconfigured server cannot stall a download for hours
+const maxRetryAfter = 5 * time.Minute
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date,
+// capped at maxRetryAfter
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(min(seconds, int(maxRetryAfter/time.Second))) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return min(max(time.Until(at), 0), maxRetryAfter), true
+	}
+	return 0, false
+}
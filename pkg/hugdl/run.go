@@ -0,0 +1,582 @@
+package hugdl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DownloadAllOptions configures DownloadAll
+type DownloadAllOptions struct {
+	// Model is the repo to download, e.g. Qwen/Qwen2.5-Coder-0.5B
+	Model string
+	// Revision is the branch, tag or commit to download; empty means DefaultRevision
+	Revision string
+	// Dest receives the files under their repo paths
+	Dest string
+	// Filter selects the files to download; nil downloads every file
+	Filter func(File) bool
+	// Concurrency is the number of files downloaded in parallel; 0 means
+	// DefaultConcurrency for this machine
+	Concurrency int
+	// Retries is how many times each file's requests are retried on network errors, 429 and 5xx
+	Retries int
+	// RetriesPerGB adds this many retries for every full GiB of a file, so large
+	// shards get more patience than small files; see RetriesForSize
+	RetriesPerGB int
+	// NoVerify skips comparing downloaded content with the repo's hashes
+	NoVerify bool
+	// NoResume discards partial files left by an interrupted download instead of resuming them
+	NoResume bool
+	// Force downloads files again even if a local copy with the expected size exists
+	Force bool
+	// Progress, if set, is called from the downloading goroutine as bytes of a file arrive
+	Progress func(file File, written int64)
+
+	// The options below serve callers that need more control, such as the hugdl command.
+
+	// Files, if set, are downloaded in this order instead of listing Model
+	Files []File
+	// Layouts, if set, replace the nested layout at Dest; each one receives every file
+	Layouts []Layout
+	// Planner decides which files are downloaded, skipped or linked. Force is copied
+	// into it; its State defaults to the one saved in the first layout's StateDir.
+	Planner Planner
+	// FileOptions is the template for each file's download; Retries, NoVerify and
+	// NoResume above are copied into it
+	FileOptions FileOptions
+	// CheckSpace fails the run before it starts if a layout's volume is too small,
+	// with a *SpaceError
+	CheckSpace bool
+	// Quarantine moves files that fail verification to the layout's quarantine folder
+	Quarantine bool
+	// LineEndings, if set to LineEndingsLF or LineEndingsCRLF, rewrites text files after verification
+	LineEndings string
+	// AbortOnChecksumMismatch stops the whole run once a file fails verification
+	AbortOnChecksumMismatch bool
+	// WriteIndex writes an index of the downloaded files to each layout
+	WriteIndex bool
+	// DoneMarker writes a marker to each layout once every file is in place
+	DoneMarker bool
+
+	// BeforeFile, if set, is called before each file; an error leaves the file not
+	// started, e.g. to hold files back while the run is paused
+	BeforeFile func(ctx context.Context) error
+	// OnStart is called when file number i starts downloading; skipped and linked files do not start
+	OnStart func(i int, file File)
+	// OnEvent receives the events of file number i's download
+	OnEvent func(i int, file File, e Event)
+	// Writers, if set, returns extra writers fed with file number i's content
+	Writers func(i int, file File) []io.Writer
+	// OpenSink, if set, returns a sink for file's content; localPath is its first output path
+	OpenSink func(ctx context.Context, file File, localPath string) (Sink, error)
+	// OnResult is called when file number i has ended
+	OnResult func(i int, result FileResult)
+	// Warn receives problems that do not fail a file, e.g. a sidecar that could not be saved
+	Warn func(error)
+}
+
+// Bounds of DefaultConcurrency. Downloads wait on the network rather than the CPU,
+// so small machines still get a couple of files in flight, while the cap keeps big
+// ones from opening more connections than the Hub's rate limits tolerate.
+const (
+	minDefaultConcurrency = 2
+	maxDefaultConcurrency = 8
+)
+
+// DefaultConcurrency is the number of files downloaded in parallel on a machine with
+// cpus CPUs when no concurrency is given: one per CPU, within 2 and 8
+func DefaultConcurrency(cpus int) int {
+	return min(max(cpus, minDefaultConcurrency), maxDefaultConcurrency)
+}
+
+// RetriesForSize returns the retries of a file of size bytes: base, plus perGB for
+// every full GiB
+func RetriesForSize(base, perGB int, size int64) int {
+	return base + perGB*int(size>>30)
+}
+
+// FileStatus tells how a file ended in a DownloadAll run
+type FileStatus string
+
+const (
+	StatusDownloaded FileStatus = "downloaded"
+	StatusSkipped    FileStatus = "skipped"
+	StatusFailed     FileStatus = "failed"
+	// StatusNotStarted: the run was cancelled before the file's turn came
+	StatusNotStarted FileStatus = "not_started"
+)
+
+// FileResult is the outcome of one file of a DownloadAll run
+type FileResult struct {
+	File   File
+	Status FileStatus
+	// Plan is the action that was planned for the file, e.g. ActionLink for a skipped file
+	Plan FilePlan
+	// Bytes is what was transferred in this run; a resumed file does not count its prefix
+	Bytes int64
+	// Duration is the time spent on the file, including retries
+	Duration time.Duration
+	// Retries counts the retries of the file's requests by reason
+	Retries RetryCounts
+	// Commit is the commit the file was served from, if the server said
+	Commit string
+	Err    error
+}
+
+// Report describes a DownloadAll run, with one result per selected file in listing order
+type Report struct {
+	Files []FileResult
+	// Commit is the commit the downloaded files came from, if the server said
+	Commit string
+	// Quarantined lists where files that failed verification were moved
+	Quarantined []string
+	// Aborted is the path of the file whose checksum mismatch stopped the run, if any
+	Aborted string
+}
+
+// Count returns the number of files that ended with status
+func (r *Report) Count(status FileStatus) int {
+	n := 0
+	for _, result := range r.Files {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Succeeded returns the number of files that are in place, downloaded or skipped
+func (r *Report) Succeeded() int {
+	return r.Count(StatusDownloaded) + r.Count(StatusSkipped)
+}
+
+// Retries totals the retries of every file by reason
+func (r *Report) Retries() RetryCounts {
+	counts := RetryCounts{}
+	for _, result := range r.Files {
+		counts.add(result.Retries)
+	}
+	return counts
+}
+
+// DownloadAll downloads a model with a client from NewClient; see Client.DownloadAll
+func DownloadAll(ctx context.Context, opts DownloadAllOptions) (*Report, error) {
+	return NewClient().DownloadAll(ctx, opts)
+}
+
+// DownloadAll lists opts.Model, downloads the selected files into opts.Dest and
+// reports how every file ended. Files whose local copy already has the expected size
+// are skipped unless opts.Force is set. Failures of individual files are joined into
+// the returned error, next to a report covering every file; the other files are still
+// downloaded unless ctx is cancelled. A failed listing or output directory that cannot
+// be prepared returns a nil report. When every file succeeded, an error is about the
+// run itself, e.g. a done marker that could not be written.
+func (c *Client) DownloadAll(ctx context.Context, opts DownloadAllOptions) (*Report, error) {
+	if opts.Revision == "" {
+		opts.Revision = DefaultRevision
+	}
+	layouts := opts.Layouts
+	if len(layouts) == 0 {
+		if opts.Dest == "" {
+			return nil, errors.New("no destination directory given")
+		}
+		layouts = []Layout{{Format: LayoutNested, ModelDir: opts.Dest, Revision: opts.Revision}}
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency(runtime.NumCPU())
+	}
+
+	files := opts.Files
+	if files == nil {
+		var err error
+		files, err = c.ListFiles(ctx, opts.Model, opts.Revision)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Filter != nil {
+		var kept []File
+		for _, file := range files {
+			if opts.Filter(file) {
+				kept = append(kept, file)
+			}
+		}
+		files = kept
+	}
+
+	if err := prepareLayouts(layouts, files, opts); err != nil {
+		return nil, err
+	}
+
+	r := &run{client: c, opts: opts, layouts: layouts, files: files}
+	r.planner = opts.Planner
+	r.planner.Force = r.planner.Force || opts.Force
+	if r.planner.State == nil {
+		r.planner.State = LoadState(layouts[0].StateDir(), opts.Model, opts.Revision)
+	}
+	// Workers download under their own context so an abort also stops the files in flight
+	workCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	r.abort = abort
+
+	// Each worker fills in the results of its own files; files never started keep the default
+	report := &Report{Files: make([]FileResult, len(files))}
+	for i, file := range files {
+		report.Files[i] = FileResult{File: file, Status: StatusNotStarted}
+	}
+	r.report = report
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// A file whose turn comes after cancellation is not started
+				if workCtx.Err() != nil {
+					continue
+				}
+				if opts.BeforeFile != nil && opts.BeforeFile(workCtx) != nil {
+					continue
+				}
+				result := r.file(workCtx, i, files[i])
+				report.Files[i] = result
+				if opts.OnResult != nil {
+					opts.OnResult(i, result)
+				}
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case jobs <- i:
+		case <-workCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	r.finish()
+
+	var errs []error
+	for _, result := range report.Files {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.File.Path, result.Err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Downstream jobs may wait for the marker, so it is only written for a complete model
+	if opts.DoneMarker && report.Succeeded() == len(files) && report.Aborted == "" {
+		var total int64
+		for _, file := range files {
+			total += file.Size
+		}
+		marker := doneMarker{Model: opts.Model, Revision: opts.Revision, Commit: report.Commit, Files: len(files), Bytes: total, Verified: !opts.NoVerify, CompletedAt: time.Now().UTC()}
+		for _, layout := range layouts {
+			if err := layout.writeDoneMarker(marker); err != nil {
+				errs = append(errs, fmt.Errorf("could not write %s: %w", DoneMarkerName, err))
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// prepareLayouts checks for files sharing a path and for free space, creates the
+// directories of every layout and removes done markers of earlier runs
+func prepareLayouts(layouts []Layout, files []File, opts DownloadAllOptions) error {
+	// One file would silently replace the other
+	for _, layout := range layouts {
+		if err := layout.CheckCollisions(files); err != nil {
+			return err
+		}
+	}
+
+	// Fail fast instead of filling the disk halfway through a large model
+	if opts.CheckSpace {
+		for _, layout := range layouts {
+			err := layout.CheckFreeSpace(files)
+			var spaceErr *SpaceError
+			if errors.As(err, &spaceErr) {
+				return err
+			}
+			if err != nil {
+				opts.warn(err)
+			}
+		}
+	}
+
+	for _, layout := range layouts {
+		if err := layout.Prepare(); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+		if layout.MaxName != 0 {
+			if err := layout.WriteNameMap(files); err != nil {
+				opts.warn(fmt.Errorf("could not record shortened file names: %w", err))
+			}
+		}
+		// A marker from an earlier run must not vouch for this one until it succeeds
+		if err := os.Remove(layout.doneMarkerPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove old %s: %w", DoneMarkerName, err)
+		}
+	}
+	return nil
+}
+
+// warn passes err to opts.Warn, if set
+func (opts DownloadAllOptions) warn(err error) {
+	if opts.Warn != nil {
+		opts.Warn(err)
+	}
+}
+
+// run is the shared state of one DownloadAll call
+type run struct {
+	client  *Client
+	opts    DownloadAllOptions
+	layouts []Layout
+	files   []File
+	planner Planner
+	report  *Report
+	abort   context.CancelFunc
+
+	// mu guards the planner's state and the report's run-level fields
+	mu sync.Mutex
+}
+
+// file plans and carries out the download of file number i
+func (r *run) file(ctx context.Context, i int, file File) (result FileResult) {
+	start := time.Now()
+	result.File = file
+	defer func() { result.Duration = time.Since(start) }()
+	why := r.planner.Explain
+
+	outputPaths, err := filePaths(r.layouts, file)
+	var parts []string
+	if err == nil {
+		parts, err = partPaths(r.layouts, file)
+	}
+	if err != nil {
+		why.Decide(file, "failed", "%v", err)
+		result.Status, result.Err = StatusFailed, err
+		return result
+	}
+
+	r.mu.Lock()
+	result.Plan = r.planner.planParts(file, outputPaths, parts)
+	r.mu.Unlock()
+	plan := result.Plan
+	why.Decide(file, plan.Action, "%s", plan.Reason)
+
+	switch plan.Action {
+	case ActionLink:
+		// Reuse a file that already exists in a reference directory
+		err := finalizeAll(r.layouts, outputPaths, file, func(outputPath string) error {
+			return linkExisting(plan.Existing, outputPath)
+		})
+		if err != nil {
+			result.Status, result.Err = StatusFailed, err
+			return result
+		}
+		result.Status = StatusSkipped
+		return result
+	case ActionSkip:
+		// Hub snapshots may still need their link to the existing blob
+		if plan.Existing == "" {
+			if err := finalizeAll(r.layouts, outputPaths, file, func(string) error { return nil }); err != nil {
+				result.Status, result.Err = StatusFailed, err
+				return result
+			}
+		}
+		result.Status = StatusSkipped
+		return result
+	}
+
+	if r.opts.OnStart != nil {
+		r.opts.OnStart(i, file)
+	}
+	result.Retries = RetryCounts{}
+	meta, transferred, err := r.download(ctx, i, file, outputPaths, parts, result.Retries)
+	result.Bytes = transferred
+	result.Commit = meta.Commit
+	if err != nil {
+		result.Status, result.Err = StatusFailed, err
+		return result
+	}
+	result.Status = StatusDownloaded
+	return result
+}
+
+// download fetches file to outputPaths through the part files at parts and applies the
+// run's post-processing, counting retries in retries. It returns the bytes transferred
+// next to the download's metadata.
+func (r *run) download(ctx context.Context, i int, file File, outputPaths, parts []string, retries RetryCounts) (Result, int64, error) {
+	opts := r.opts
+	hasher := r.planner.Hasher
+
+	transfer := &transferCounter{}
+	fileOpts := opts.FileOptions
+	fileOpts.Retries = RetriesForSize(opts.Retries, opts.RetriesPerGB, file.Size)
+	fileOpts.NoVerify, fileOpts.NoResume = opts.NoVerify, opts.NoResume
+	fileOpts.PartPaths = parts
+	fileOpts.KeepCorrupt = fileOpts.KeepCorrupt || opts.Quarantine
+	fileOpts.Writers = append([]io.Writer{transfer}, opts.FileOptions.Writers...)
+	if opts.Progress != nil {
+		fileOpts.Writers = append(fileOpts.Writers, &progressWriter{file: file, report: opts.Progress})
+	}
+	if opts.Writers != nil {
+		fileOpts.Writers = append(fileOpts.Writers, opts.Writers(i, file)...)
+	}
+	fileOpts.OnEvent = func(e Event) {
+		switch e.Kind {
+		case EventStart:
+			transfer.skip = e.Offset
+		case EventRetry:
+			retries[e.Reason]++
+		case EventVerified:
+			// A verified download need not be hashed again by a later run
+			hasher.remember(e.Path, file)
+		}
+		if opts.OnEvent != nil {
+			opts.OnEvent(i, file, e)
+		}
+	}
+	if opts.OpenSink != nil {
+		fileOpts.OpenSink = func(ctx context.Context) (Sink, error) {
+			return opts.OpenSink(ctx, file, outputPaths[0])
+		}
+	}
+	meta, err := r.client.DownloadFile(ctx, opts.Model, opts.Revision, file, outputPaths, fileOpts)
+
+	// Mirrors that received the file are kept even if others failed
+	var mirrorErr *MirrorError
+	if err == nil || errors.As(err, &mirrorErr) {
+		finalizeErr := finalizeAll(r.layouts, outputPaths, file, func(outputPath string) error {
+			return mirrorErr.Failed(outputPath)
+		})
+		if err == nil {
+			err = finalizeErr
+		}
+	}
+	// Keep corrupt downloads for inspection if requested
+	var sumErr *ChecksumError
+	errors.As(err, &sumErr)
+	if opts.Quarantine && sumErr != nil {
+		// One layout failing must not leave the corrupt copies of the others in place
+		for j, layout := range r.layouts {
+			moved, qerr := layout.Quarantine(outputPaths[j], file)
+			if qerr != nil {
+				err = errors.Join(err, fmt.Errorf("could not quarantine %s: %w", outputPaths[j], qerr))
+				os.Remove(outputPaths[j])
+				continue
+			}
+			r.mu.Lock()
+			r.report.Quarantined = append(r.report.Quarantined, moved)
+			r.mu.Unlock()
+		}
+	}
+	// Verification happened while downloading, so text files can be rewritten now
+	normalized := false
+	if err == nil && opts.LineEndings != "" && IsTextFile(file.Path) {
+		for _, outputPath := range outputPaths {
+			changed, nerr := normalizeLineEndings(outputPath, opts.LineEndings)
+			if nerr != nil {
+				err = errors.Join(err, nerr)
+			}
+			normalized = normalized || changed
+		}
+	}
+	if err != nil {
+		if opts.AbortOnChecksumMismatch && sumErr != nil {
+			r.mu.Lock()
+			if r.report.Aborted == "" {
+				r.report.Aborted = file.Path
+				r.abort()
+			}
+			r.mu.Unlock()
+		}
+		return meta, transfer.n, err
+	}
+
+	r.mu.Lock()
+	r.planner.State.record(file, meta)
+	r.mu.Unlock()
+	// Mirrors are normalized alike, so the first copy stands for all of them
+	if normalized {
+		if nerr := r.planner.State.recordLocal(file, outputPaths[0], hasher, &r.mu); nerr != nil {
+			opts.warn(fmt.Errorf("could not record the normalized %s: %w", file.Path, nerr))
+		}
+	}
+	return meta, transfer.n, nil
+}
+
+// finish saves the run's sidecars: the download state, the checksum cache and the index
+func (r *run) finish() {
+	state := r.planner.State
+	r.report.Commit = state.Commit
+
+	saved := map[string]bool{}
+	for _, layout := range r.layouts {
+		if dir := layout.StateDir(); !saved[dir] {
+			saved[dir] = true
+			if err := state.save(dir); err != nil {
+				r.opts.warn(fmt.Errorf("could not save download state: %w", err))
+			}
+		}
+	}
+	if err := r.planner.Hasher.Cache().Save(); err != nil {
+		r.opts.warn(fmt.Errorf("could not save checksum cache: %w", err))
+	}
+
+	// Describe what is actually on disk for downstream tooling
+	if r.opts.WriteIndex {
+		for _, layout := range r.layouts {
+			if err := r.client.writeIndex(layout, r.files, state); err != nil {
+				r.opts.warn(fmt.Errorf("could not write index: %w", err))
+			}
+		}
+	}
+}
+
+// transferCounter counts the bytes that came over the network. Writers are fed a
+// resumed prefix from disk first; skip is set to its length when the download starts.
+type transferCounter struct {
+	n    int64
+	skip int64
+}
+
+func (t *transferCounter) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	if t.skip > 0 {
+		skipped := min(t.skip, n)
+		t.skip -= skipped
+		n -= skipped
+	}
+	t.n += n
+	return len(p), nil
+}
+
+// progressWriter reports the running byte count of one file
+type progressWriter struct {
+	file    File
+	written int64
+	report  func(File, int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	p.report(p.file, p.written)
+	return len(b), nil
+}
@@ -0,0 +1,618 @@
+package hugdl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"downloader/internal/units"
+)
+
+// Glob is one include or exclude pattern. Unlike path.Match, * and ? also
+// match slashes, so "*.gguf" selects GGUF files in subdirectories too.
+type Glob struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// ParseGlobs compiles a comma-separated list of case-insensitive globs
+func ParseGlobs(list string) ([]Glob, error) {
+	return parseGlobs(strings.Split(list, ","))
+}
+
+// ReadGlobs compiles the globs in a pattern file: one per line, where blank lines
+// and lines starting with # are skipped. A comma is part of the pattern.
+func ReadGlobs(path string) ([]Glob, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	globs, err := parseGlobs(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return globs, nil
+}
+
+// parseGlobs compiles patterns, skipping empty ones
+func parseGlobs(patterns []string) ([]Glob, error) {
+	var globs []Glob
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := compileGlob(pattern, globOptions{foldCase: true, crossSlash: true})
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, Glob{Pattern: pattern, re: re})
+	}
+	return globs, nil
+}
+
+// Match reports whether repoPath matches the glob
+func (g Glob) Match(repoPath string) bool {
+	return g.re.MatchString(repoPath)
+}
+
+// globOptions selects the dialect compileGlob translates
+type globOptions struct {
+	foldCase   bool // letters match either case
+	crossSlash bool // * and ? match slashes too; otherwise only ** crosses directories
+}
+
+// compileGlob translates a glob using *, ?, [...] classes and ** into an anchored regexp
+func compileGlob(pattern string, opts globOptions) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	if opts.foldCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case opts.crossSlash && c == '*':
+			expr.WriteString(".*")
+		case opts.crossSlash && c == '?':
+			expr.WriteString(".")
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// matchGlobs returns the first glob matching repoPath, or nil
+func matchGlobs(globs []Glob, repoPath string) *Glob {
+	for i := range globs {
+		if globs[i].Match(repoPath) {
+			return &globs[i]
+		}
+	}
+	return nil
+}
+
+// lfsPattern is one .gitattributes line that sets or unsets filter=lfs
+type lfsPattern struct {
+	re    *regexp.Regexp
+	base  bool // pattern has no slash and matches the file name at any depth
+	isLFS bool
+}
+
+// parseGitAttributes extracts the filter=lfs patterns from .gitattributes content
+func parseGitAttributes(content string) []lfsPattern {
+	var patterns []lfsPattern
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, attr := range fields[1:] {
+			var isLFS bool
+			switch attr {
+			case "filter=lfs":
+				isLFS = true
+			case "-filter", "!filter":
+				isLFS = false
+			default:
+				continue
+			}
+
+			pattern := fields[0]
+			base := !strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+			re, err := compileGlob(strings.TrimPrefix(pattern, "/"), globOptions{})
+			if err == nil {
+				patterns = append(patterns, lfsPattern{re: re, base: base, isLFS: isLFS})
+			}
+		}
+	}
+	return patterns
+}
+
+// matchesLFS reports whether the last pattern matching path marks it as LFS
+func matchesLFS(patterns []lfsPattern, path string) bool {
+	isLFS := false
+	for _, p := range patterns {
+		subject := path
+		if p.base {
+			subject = pathBase(path)
+		}
+		if p.re.MatchString(subject) {
+			isLFS = p.isLFS
+		}
+	}
+	return isLFS
+}
+
+// pathBase returns the last element of a slash-separated repo path
+func pathBase(repoPath string) string {
+	return repoPath[strings.LastIndexByte(repoPath, '/')+1:]
+}
+
+// Explainer collects the reasons behind each file's download decision.
+// All methods are no-ops on a nil Explainer and safe for concurrent use.
+type Explainer struct {
+	mu      sync.Mutex
+	reasons map[string][]string
+	decided func(file File, decision string, reasons []string)
+}
+
+// NewExplainer returns an Explainer that calls decided with every reason recorded
+// for a file once its decision is made. Calls to decided do not overlap.
+func NewExplainer(decided func(file File, decision string, reasons []string)) *Explainer {
+	return &Explainer{reasons: map[string][]string{}, decided: decided}
+}
+
+// Note records a reason that contributed to file's decision
+func (e *Explainer) Note(file File, format string, args ...any) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reasons[file.Path] = append(e.reasons[file.Path], fmt.Sprintf(format, args...))
+}
+
+// Decide records the final reason and reports the decision for file
+func (e *Explainer) Decide(file File, decision, format string, args ...any) {
+	if e == nil {
+		return
+	}
+	e.Note(file, format, args...)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decided(file, decision, e.reasons[file.Path])
+}
+
+// quantPattern finds the quantization label in a GGUF file name
+var quantPattern = regexp.MustCompile(`(?i)(?:^|[-_.])(I?Q\d+_[A-Z0-9]+(?:_[A-Z0-9]+)?|Q\d+|BF16|F16|F32)(?:[-_.]|$)`)
+
+// QuantOption is one GGUF quantization of a model, possibly split over several shards
+type QuantOption struct {
+	Label string
+	Files []File
+	Size  int64
+	Fits  bool
+}
+
+// quantOverhead is the headroom assumed on top of the weights for context and runtime buffers
+const quantOverhead = 1.2
+
+// ChooseQuant picks the largest quantization whose weights plus overhead fit in budget,
+// or the smallest one if none fit. It reports false when there are no GGUF files.
+func ChooseQuant(files []File, budget int64) (QuantOption, bool) {
+	groups := map[string]*QuantOption{}
+	var labels []string
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(strings.ToLower(name), ".gguf") {
+			continue
+		}
+		label := name
+		if m := quantPattern.FindStringSubmatch(name); m != nil {
+			label = strings.ToUpper(m[1])
+		}
+		if groups[label] == nil {
+			groups[label] = &QuantOption{Label: label}
+			labels = append(labels, label)
+		}
+		groups[label].Files = append(groups[label].Files, file)
+		groups[label].Size += file.Size
+	}
+	if len(labels) == 0 {
+		return QuantOption{}, false
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		if groups[labels[i]].Size != groups[labels[j]].Size {
+			return groups[labels[i]].Size < groups[labels[j]].Size
+		}
+		return labels[i] < labels[j]
+	})
+
+	var best *QuantOption
+	for _, label := range labels {
+		if float64(groups[label].Size)*quantOverhead <= float64(budget) {
+			best = groups[label]
+		}
+	}
+	if best == nil {
+		return *groups[labels[0]], true
+	}
+	best.Fits = true
+	return *best, true
+}
+
+// Download orders accepted by SortFiles
+const (
+	OrderPath     = "path"
+	OrderSize     = "size"
+	OrderSizeDesc = "size-desc"
+	OrderIndex    = "index"
+)
+
+// SortFiles orders files in place. Ties are broken by path so the result is
+// deterministic for any input order. The index order puts shard indexes first,
+// then the other small files, then shards in the order given by shards.
+func SortFiles(files []File, order string, shards []string) error {
+	var less func(a, b File) bool
+	switch order {
+	case OrderPath:
+		less = func(a, b File) bool { return false }
+	case OrderSize:
+		less = func(a, b File) bool { return a.Size < b.Size }
+	case OrderSizeDesc:
+		less = func(a, b File) bool { return a.Size > b.Size }
+	case OrderIndex:
+		position := map[string]int{}
+		for i, shard := range shards {
+			position[shard] = i
+		}
+		rank := func(f File) int {
+			if isShardIndex(f.Path) {
+				return -2
+			}
+			if i, ok := position[f.Path]; ok {
+				return i
+			}
+			return -1
+		}
+		less = func(a, b File) bool { return rank(a) < rank(b) }
+	default:
+		return fmt.Errorf("unknown order %q (want path, size, size-desc or index)", order)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if less(files[i], files[j]) {
+			return true
+		}
+		if less(files[j], files[i]) {
+			return false
+		}
+		return files[i].Path < files[j].Path
+	})
+	return nil
+}
+
+// isShardIndex reports whether repoPath is a sharded checkpoint index such as model.safetensors.index.json
+func isShardIndex(repoPath string) bool {
+	return strings.HasSuffix(repoPath, ".index.json")
+}
+
+// parseShardOrder returns the distinct shard names of an index's weight_map in
+// document order, which a Go map would lose
+func parseShardOrder(data []byte) ([]string, error) {
+	var index struct {
+		WeightMap json.RawMessage `json:"weight_map"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index: %w", err)
+	}
+	if len(index.WeightMap) == 0 {
+		return nil, errors.New("index has no weight_map")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(index.WeightMap))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("weight_map is not an object")
+	}
+	seen := map[string]bool{}
+	var shards []string
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		var shard string
+		if err := dec.Decode(&shard); err != nil {
+			return nil, fmt.Errorf("invalid weight_map entry: %w", err)
+		}
+		if !seen[shard] {
+			seen[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+// TreeDiff compares two listings of a repo by path. changed holds the paths in current
+// that are new or whose size or content hash differs from previous; removed lists the
+// paths only in previous, sorted.
+func TreeDiff(previous, current []File) (changed map[string]bool, removed []string) {
+	before := make(map[string]File, len(previous))
+	for _, file := range previous {
+		before[file.Path] = file
+	}
+
+	changed = map[string]bool{}
+	for _, file := range current {
+		old, ok := before[file.Path]
+		if !ok || old.Type != file.Type || old.Size != file.Size || old.ExpectedOid() != file.ExpectedOid() {
+			changed[file.Path] = true
+		}
+		delete(before, file.Path)
+	}
+	for path := range before {
+		removed = append(removed, path)
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// Selection narrows a listing down to the files to download. Its filters run in the
+// order of its fields; the zero value keeps every file in path order.
+type Selection struct {
+	SkipSymlinks   bool
+	SkipSubmodules bool
+	// Since, if set, keeps only the files added or changed since the listing Previous
+	// was taken at; Since names that revision in explanations
+	Since    string
+	Previous []File
+	// Regexp, if set, keeps only the repo paths it matches
+	Regexp *regexp.Regexp
+	// Include keeps only paths matching one of its globs, unless Exclude matches them too
+	Include []Glob
+	Exclude []Glob
+	// Transform drops files with no path left after its StripComponents, like tar
+	Transform PathTransform
+	SkipLFS   bool
+	OnlyLFS   bool
+	// AutoQuant keeps only the GGUF quantization ChooseQuant picks for MemoryBudget
+	AutoQuant    bool
+	MemoryBudget int64
+	// Order is one of the SortFiles orders; empty means OrderPath. For OrderIndex,
+	// Shards returns the shard order of the selected files.
+	Order  string
+	Shards func(files []File) ([]string, error)
+	// MaxFiles keeps the first MaxFiles files in Order (0 = no limit)
+	MaxFiles int
+}
+
+// Names of the SelectSteps a Selection reports
+const (
+	StepLinks    = "links"
+	StepSince    = "since"
+	StepRegexp   = "regexp"
+	StepGlobs    = "globs"
+	StepStrip    = "strip"
+	StepLFS      = "lfs"
+	StepQuant    = "quant"
+	StepMaxFiles = "max-files"
+)
+
+// SelectStep is one filter of a Selection that ran, with the file counts around it
+type SelectStep struct {
+	Name   string
+	Before int
+	After  int
+}
+
+// SelectResult is the outcome of Selection.Apply
+type SelectResult struct {
+	Files []File
+	Steps []SelectStep
+	// Removed lists the paths removed since Selection.Since
+	Removed []string
+	// Quant is the quantization AutoQuant chose
+	Quant QuantOption
+	// ShardErr is why Shards failed; shards are then ordered by path
+	ShardErr error
+}
+
+// Step returns the step called name and whether it ran
+func (r SelectResult) Step(name string) (SelectStep, bool) {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return SelectStep{}, false
+}
+
+// Apply selects and orders files, recording in why why each file was excluded.
+// files is not modified.
+func (s Selection) Apply(files []File, why *Explainer) (SelectResult, error) {
+	var result SelectResult
+	files = append([]File(nil), files...)
+	filter := func(name string, keep func(File) bool) {
+		var kept []File
+		for _, file := range files {
+			if keep(file) {
+				kept = append(kept, file)
+			}
+		}
+		result.Steps = append(result.Steps, SelectStep{Name: name, Before: len(files), After: len(kept)})
+		files = kept
+	}
+
+	// Symlinks and submodule pointers are not model files unless asked for
+	if s.SkipSymlinks || s.SkipSubmodules {
+		filter(StepLinks, func(file File) bool {
+			switch {
+			case s.SkipSymlinks && file.Type == TypeSymlink:
+				why.Decide(file, "excluded", "symlinks are skipped")
+				return false
+			case s.SkipSubmodules && file.Type == TypeSubmodule:
+				why.Decide(file, "excluded", "submodules are skipped")
+				return false
+			}
+			return true
+		})
+	}
+
+	// Keep only files whose content changed since a known commit
+	if s.Since != "" {
+		changed, removed := TreeDiff(s.Previous, files)
+		result.Removed = removed
+		filter(StepSince, func(file File) bool {
+			if !changed[file.Path] {
+				why.Decide(file, "excluded", "unchanged since %s", s.Since)
+				return false
+			}
+			why.Note(file, "added or changed since %s", s.Since)
+			return true
+		})
+	}
+
+	if s.Regexp != nil {
+		filter(StepRegexp, func(file File) bool {
+			if !s.Regexp.MatchString(file.Path) {
+				why.Decide(file, "excluded", "path does not match %s", s.Regexp)
+				return false
+			}
+			why.Note(file, "matched %s", s.Regexp)
+			return true
+		})
+	}
+
+	// Excludes win over includes
+	if len(s.Include) > 0 || len(s.Exclude) > 0 {
+		total := len(files)
+		filter(StepGlobs, func(file File) bool {
+			if glob := matchGlobs(s.Exclude, file.Path); glob != nil {
+				why.Decide(file, "excluded", "matches exclude pattern %s", glob.Pattern)
+				return false
+			}
+			if len(s.Include) == 0 {
+				return true
+			}
+			glob := matchGlobs(s.Include, file.Path)
+			if glob == nil {
+				why.Decide(file, "excluded", "matches no include pattern")
+				return false
+			}
+			why.Note(file, "matches include pattern %s", glob.Pattern)
+			return true
+		})
+		if len(files) == 0 {
+			return result, fmt.Errorf("no files match the include and exclude patterns (the repo has %d files); check the patterns", total)
+		}
+	}
+
+	if s.Transform.StripComponents > 0 {
+		filter(StepStrip, func(file File) bool {
+			if _, ok := s.Transform.stripped(file.Path); !ok {
+				why.Decide(file, "excluded", "no path left after stripping %d components", s.Transform.StripComponents)
+				return false
+			}
+			return true
+		})
+	}
+
+	if s.SkipLFS || s.OnlyLFS {
+		filter(StepLFS, func(file File) bool {
+			switch {
+			case s.SkipLFS && file.LFS:
+				why.Decide(file, "excluded", "LFS files are skipped")
+				return false
+			case s.OnlyLFS && !file.LFS:
+				why.Decide(file, "excluded", "not an LFS file, and only LFS files are kept")
+				return false
+			}
+			return true
+		})
+	}
+
+	// Pick a single GGUF quantization that fits the memory budget
+	if s.AutoQuant {
+		choice, ok := ChooseQuant(files, s.MemoryBudget)
+		if !ok {
+			return result, errors.New("no GGUF files in this repo")
+		}
+		result.Quant = choice
+		inChoice := map[string]bool{}
+		for _, file := range choice.Files {
+			inChoice[file.Path] = true
+		}
+		filter(StepQuant, func(file File) bool {
+			if !inChoice[file.Path] {
+				why.Decide(file, "excluded", "not the quant chosen for %s of memory (%s)", units.FormatSize(s.MemoryBudget), choice.Label)
+				return false
+			}
+			why.Note(file, "chosen quant for %s of memory", units.FormatSize(s.MemoryBudget))
+			return true
+		})
+	}
+
+	// Sort so runs are reproducible regardless of API response order
+	order := s.Order
+	if order == "" {
+		order = OrderPath
+	}
+	var shards []string
+	if order == OrderIndex && s.Shards != nil {
+		shards, result.ShardErr = s.Shards(files)
+	}
+	if err := SortFiles(files, order, shards); err != nil {
+		return result, err
+	}
+
+	if s.MaxFiles > 0 && len(files) > s.MaxFiles {
+		for _, file := range files[s.MaxFiles:] {
+			why.Decide(file, "excluded", "beyond the first %d files in %s order", s.MaxFiles, order)
+		}
+		result.Steps = append(result.Steps, SelectStep{Name: StepMaxFiles, Before: len(files), After: s.MaxFiles})
+		files = files[:s.MaxFiles]
+	}
+
+	// A nil Files would make DownloadAll list the repo again
+	if files == nil {
+		files = []File{}
+	}
+	result.Files = files
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package hugdl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateFileName is the sidecar recording what was downloaded from which commit
+const StateFileName = ".hugdl-state.json"
+
+// State records the commit and per-file metadata of a model download
+type State struct {
+	Model    string               `json:"model"`
+	Revision string               `json:"revision"`
+	Commit   string               `json:"commit,omitempty"`
+	Files    map[string]FileState `json:"files"`
+
+	maxAge time.Duration
+}
+
+// FileState is the recorded metadata of one downloaded file. LocalSize and LocalOid
+// describe the local copy when its line endings were rewritten.
+type FileState struct {
+	Size       int64     `json:"size"`
+	Oid        string    `json:"oid,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	Commit     string    `json:"commit,omitempty"`
+	LocalSize  int64     `json:"local_size,omitempty"`
+	LocalOid   string    `json:"local_oid,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// LoadState reads the state sidecar in dir, starting fresh if it is missing or for another revision
+func LoadState(dir, model, revision string) *State {
+	state := &State{Model: model, Revision: revision, Files: map[string]FileState{}}
+
+	data, err := os.ReadFile(filepath.Join(dir, StateFileName))
+	if err != nil {
+		return state
+	}
+	var saved State
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Model != model || saved.Revision != revision || saved.Files == nil {
+		return state
+	}
+	return &saved
+}
+
+// record stores the metadata of a successful download. It returns the commit other
+// files came from if meta names a different one.
+func (s *State) record(file File, meta Result) (otherCommit string) {
+	if meta.Commit != "" {
+		if s.Commit != "" && s.Commit != meta.Commit {
+			otherCommit = s.Commit
+		}
+		s.Commit = meta.Commit
+	}
+	s.Files[file.Path] = FileState{
+		Size:       file.Size,
+		Oid:        file.ExpectedOid(),
+		ETag:       meta.ETag,
+		Commit:     meta.Commit,
+		RecordedAt: time.Now(),
+	}
+	return otherCommit
+}
+
+// recordLocal stores the size and hash of the copy of file at path after it was
+// rewritten locally. mu guards the state while the copy is hashed.
+func (s *State) recordLocal(file File, path string, hasher *Hasher, mu *sync.Mutex) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	oid, err := hasher.FileOid(path, file.LFS)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	entry := s.Files[file.Path]
+	entry.LocalSize = stat.Size()
+	entry.LocalOid = oid
+	entry.RecordedAt = time.Now()
+	s.Files[file.Path] = entry
+	return nil
+}
+
+// SetMaxAge makes LocalFile ignore entries recorded longer than maxAge ago, or
+// without a timestamp; 0 trusts entries of any age
+func (s *State) SetMaxAge(maxAge time.Duration) {
+	if s != nil {
+		s.maxAge = maxAge
+	}
+}
+
+// expired reports whether entry is too old to be trusted
+func (s *State) expired(entry FileState) bool {
+	return s.maxAge > 0 && time.Since(entry.RecordedAt) > s.maxAge
+}
+
+// LocalFile returns file with the size and hash its local copy should have: those
+// recorded after its line endings were rewritten, as long as the repo still holds
+// the content that was rewritten and the record has not expired, and the repo's
+// otherwise
+func (s *State) LocalFile(file File) File {
+	if s == nil {
+		return file
+	}
+	entry, ok := s.Files[file.Path]
+	if !ok || entry.LocalOid == "" || entry.Oid != file.ExpectedOid() || s.expired(entry) {
+		return file
+	}
+	file.Size = entry.LocalSize
+	if file.LFS {
+		file.LFSOid = entry.LocalOid
+	} else {
+		file.Oid = entry.LocalOid
+	}
+	return file
+}
+
+// save writes the state sidecar to dir
+func (s *State) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, StateFileName), data, 0644)
+}
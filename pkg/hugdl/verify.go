@@ -0,0 +1,286 @@
+package hugdl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Hasher hashes local files the way the Hub does, bounding how many are hashed at
+// once and trusting a ChecksumCache for unchanged files. A nil Hasher hashes
+// without either. It is safe for concurrent use.
+type Hasher struct {
+	cache *ChecksumCache
+	slots chan struct{}
+}
+
+// NewHasher returns a Hasher running at most threads hashes at once (0 = no bound)
+// that consults and fills cache, which may be nil
+func NewHasher(threads int, cache *ChecksumCache) *Hasher {
+	h := &Hasher{cache: cache}
+	if threads > 0 {
+		h.slots = make(chan struct{}, threads)
+	}
+	return h
+}
+
+// Cache returns the Hasher's checksum cache, or nil
+func (h *Hasher) Cache() *ChecksumCache {
+	if h == nil {
+		return nil
+	}
+	return h.cache
+}
+
+// FileOid hashes a local file: SHA256 for LFS files, git blob SHA1 otherwise
+func (h *Hasher) FileOid(path string, lfs bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := h.Cache().lookup(path, stat, lfs); ok {
+		return oid, nil
+	}
+	if h != nil && h.slots != nil {
+		h.slots <- struct{}{}
+		defer func() { <-h.slots }()
+	}
+	hash := NewOidHash(lfs, stat.Size())
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	oid := hex.EncodeToString(hash.Sum(nil))
+	h.Cache().store(path, stat, lfs, oid)
+	return oid, nil
+}
+
+// remember adds a file known to hash to oid, e.g. a verified download, to the cache
+func (h *Hasher) remember(path string, file File) {
+	if stat, err := os.Stat(path); err == nil {
+		h.Cache().store(path, stat, file.LFS, file.ExpectedOid())
+	}
+}
+
+// ChecksumCacheName is the sidecar holding a ChecksumCache
+const ChecksumCacheName = ".hugdl-checksums.json"
+
+// ChecksumCache remembers the oids of local files keyed by absolute path, trusted
+// while the file keeps the size and modification time it had when it was hashed
+// and, with SetMaxAge, until the entry gets too old. All methods are no-ops on a
+// nil cache and safe for concurrent use.
+type ChecksumCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]checksumEntry
+	maxAge  time.Duration
+	hits    int
+	changed bool
+}
+
+// checksumEntry is one hashed file of a ChecksumCache
+type checksumEntry struct {
+	Size     int64     `json:"size"`
+	ModTime  int64     `json:"mtime_ns"`
+	LFS      bool      `json:"lfs"`
+	Oid      string    `json:"oid"`
+	HashedAt time.Time `json:"hashed_at"`
+}
+
+// LoadChecksumCache reads the cache in dir; a missing or unreadable cache starts empty
+func LoadChecksumCache(dir string) *ChecksumCache {
+	c := &ChecksumCache{path: filepath.Join(dir, ChecksumCacheName), entries: map[string]checksumEntry{}}
+	if data, err := os.ReadFile(c.path); err == nil {
+		if json.Unmarshal(data, &c.entries) != nil {
+			c.entries = map[string]checksumEntry{}
+		}
+	}
+	return c
+}
+
+// cacheKey makes path independent of the working directory
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// SetMaxAge makes the cache hash files again once their entry is older than
+// maxAge, or has no timestamp; 0 trusts entries of any age
+func (c *ChecksumCache) SetMaxAge(maxAge time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxAge = maxAge
+}
+
+// lookup returns the cached oid of path if the file still has the size and
+// modification time it was hashed with and the entry has not expired
+func (c *ChecksumCache) lookup(path string, stat os.FileInfo, lfs bool) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(path)]
+	if !ok || entry.Size != stat.Size() || entry.ModTime != stat.ModTime().UnixNano() || entry.LFS != lfs {
+		return "", false
+	}
+	if c.maxAge > 0 && time.Since(entry.HashedAt) > c.maxAge {
+		return "", false
+	}
+	c.hits++
+	return entry.Oid, true
+}
+
+// store records the oid of path as hashed when it had the metadata in stat
+func (c *ChecksumCache) store(path string, stat os.FileInfo, lfs bool, oid string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(path)] = checksumEntry{Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), LFS: lfs, Oid: oid, HashedAt: time.Now()}
+	c.changed = true
+}
+
+// Hits returns how many files were trusted without hashing
+func (c *ChecksumCache) Hits() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// Save writes the cache back, dropping files that no longer exist
+func (c *ChecksumCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.entries, path)
+			c.changed = true
+		}
+	}
+	if !c.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// VerifyResult is the outcome of checking one local file against the repo
+type VerifyResult struct {
+	File File
+	Err  error
+}
+
+// VerifyLocalFiles checks the files under dir, at their repo paths, against their
+// sizes and repo oids using up to workers goroutines. Results are returned in the
+// order of files.
+func VerifyLocalFiles(dir string, files []File, workers int, hasher *Hasher) []VerifyResult {
+	results := make([]VerifyResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				path := filepath.Join(dir, filepath.FromSlash(files[i].Path))
+				results[i] = VerifyResult{File: files[i], Err: checkLocalCopy(path, files[i], hasher)}
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// checkLocalCopies checks every mirror's copy of file, returning the first problem found
+func checkLocalCopies(paths []string, file File, hasher *Hasher) error {
+	for _, path := range paths {
+		if err := checkLocalCopy(path, file, hasher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLocalSizes checks that every mirror's copy of file exists with the expected size
+func checkLocalSizes(paths []string, file File) error {
+	for _, path := range paths {
+		if err := checkLocalSize(path, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errMissing reports a local copy that does not exist
+var errMissing = errors.New("missing")
+
+// checkLocalSize checks that the file at path exists and has file's size
+func checkLocalSize(path string, file File) error {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return errMissing
+	}
+	if err != nil {
+		return err
+	}
+	if stat.Size() != file.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, found %d", file.Size, stat.Size())
+	}
+	return nil
+}
+
+// checkLocalCopy checks the file at path against file's size and repo oid
+func checkLocalCopy(path string, file File, hasher *Hasher) error {
+	if err := checkLocalSize(path, file); err != nil {
+		return err
+	}
+
+	expected := file.ExpectedOid()
+	if expected == "" {
+		return nil
+	}
+	actual, err := hasher.FileOid(path, file.LFS)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}